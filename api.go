@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// errNotFound is returned by apiClient.get if the server responded with 404.
+var errNotFound = errors.New("not found")
+
+// maxRetries is the number of times a request is retried if the server is
+// overloaded.
+const maxRetries = 5
+
+// apiClient performs requests against a Prometheus-compatible HTTP API.
+// params are added to all requests.
+type apiClient struct {
+	baseURL string
+	header  http.Header
+	params  url.Values
+}
+
+// newAPIClient returns an apiClient for the given base URL.
+// If tenants are given, requests are scoped to these tenants using the
+// X-Scope-OrgID header as understood by Cortex and Mimir. Multiple tenants are
+// queried using tenant federation.
+func newAPIClient(baseURL string, tenants []string) apiClient {
+	c := apiClient{baseURL: baseURL, header: http.Header{}}
+	if len(tenants) > 0 {
+		c.header.Set("X-Scope-OrgID", strings.Join(tenants, "|"))
+	}
+	return c
+}
+
+// get requests the given API path with the given query parameters and
+// returns the response body.
+func (c apiClient) get(path string, params url.Values) ([]byte, error) {
+	return c.do("GET", path, params)
+}
+
+// post works like get, but sends the parameters as form-encoded request
+// body, which avoids URL length limits.
+func (c apiClient) post(path string, params url.Values) ([]byte, error) {
+	return c.do("POST", path, params)
+}
+
+// do sends a request using the given method.
+func (c apiClient) do(method, path string, params url.Values) ([]byte, error) {
+	q := url.Values{}
+	for k, v := range c.params {
+		q[k] = v
+	}
+	for k, v := range params {
+		q[k] = v
+	}
+	limiter := getRateLimiter(c.baseURL)
+	for attempt := 0; ; attempt++ {
+		var body io.Reader
+		if method == "POST" {
+			body = strings.NewReader(q.Encode())
+		}
+		req, err := http.NewRequest(method, fmt.Sprintf("%s%s", c.baseURL, path), body)
+		if err != nil {
+			return nil, err
+		}
+		if method == "POST" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req.URL.RawQuery = q.Encode()
+		}
+		for k, v := range c.header {
+			req.Header[k] = v
+		}
+		limiter.wait()
+		client := http.Client{}
+		stats.add(&stats.requests, 1)
+		start := time.Now()
+		resp, err := client.Do(req)
+		log.WithFields(log.Fields{"url": req.URL, "resp": resp, "err": err}).Debug("API request result")
+		if err != nil {
+			return nil, err
+		}
+		limiter.observe(time.Since(start), resp.StatusCode)
+		if isOverloaded(resp.StatusCode) && attempt < maxRetries {
+			resp.Body.Close()
+			d := retryDelay(resp, attempt)
+			log.WithFields(log.Fields{"url": req.URL, "statusCode": resp.StatusCode, "attempt": attempt + 1, "delay": d}).Debug("Retrying request")
+			stats.add(&stats.retries, 1)
+			time.Sleep(d)
+			continue
+		}
+		return readResponse(resp)
+	}
+}
+
+// retryDelay returns how long to wait before retrying a request after the
+// given overload response: the Retry-After header (in seconds) if given,
+// exponential backoff otherwise.
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second
+	}
+	return (100 * time.Millisecond) << uint(attempt)
+}
+
+// readResponse reads the body of the given response and returns an error
+// unless the request succeeded.
+func readResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return b, nil
+}
+
+// getLabelValues retrieves all values of the given label, optionally limited
+// to the series matching the given selectors.
+func getLabelValues(c apiClient, label string, match []string) ([]string, error) {
+	params := url.Values{}
+	for _, m := range match {
+		params.Add("match[]", m)
+	}
+	b, err := c.get(fmt.Sprintf("/api/v1/label/%s/values", url.PathEscape(label)), params)
+	if err != nil {
+		return nil, err
+	}
+	j := struct {
+		Status string
+		Data   []string
+	}{}
+	err = json.Unmarshal(b, &j)
+	if err != nil {
+		return nil, err
+	}
+	if j.Status != "success" {
+		return nil, fmt.Errorf("unexpected status %q", j.Status)
+	}
+	return j.Data, nil
+}
+
+// getSeries retrieves the label sets of all series matching any of the given
+// selectors within the given time range. If limit is positive, it is passed
+// on to the server, which may ignore it if it does not support limits.
+func getSeries(c apiClient, match []string, start, end time.Time, limit int) ([]map[string]string, error) {
+	params := url.Values{}
+	for _, m := range match {
+		params.Add("match[]", m)
+	}
+	params.Set("start", start.Format(time.RFC3339))
+	params.Set("end", end.Format(time.RFC3339))
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	b, err := c.get("/api/v1/series", params)
+	if err != nil {
+		return nil, err
+	}
+	j := struct {
+		Status string
+		Data   []map[string]string
+	}{}
+	err = json.Unmarshal(b, &j)
+	if err != nil {
+		return nil, err
+	}
+	if j.Status != "success" {
+		return nil, fmt.Errorf("unexpected status %q", j.Status)
+	}
+	return j.Data, nil
+}
+
+// getLabelNames retrieves all label names, optionally limited to the series
+// matching the given selectors.
+func getLabelNames(c apiClient, match []string) ([]string, error) {
+	params := url.Values{}
+	for _, m := range match {
+		params.Add("match[]", m)
+	}
+	b, err := c.get("/api/v1/labels", params)
+	if err != nil {
+		return nil, err
+	}
+	j := struct {
+		Status string
+		Data   []string
+	}{}
+	err = json.Unmarshal(b, &j)
+	if err != nil {
+		return nil, err
+	}
+	if j.Status != "success" {
+		return nil, fmt.Errorf("unexpected status %q", j.Status)
+	}
+	return j.Data, nil
+}
+
+// metricMetadata is the metadata of a metric as returned by the metadata API.
+type metricMetadata struct {
+	Type string
+	Help string
+	Unit string
+}
+
+// getMetadata retrieves the metadata of all metrics, keyed by metric name.
+func getMetadata(c apiClient) (map[string][]metricMetadata, error) {
+	b, err := c.get("/api/v1/metadata", nil)
+	if err != nil {
+		return nil, err
+	}
+	j := struct {
+		Status string
+		Data   map[string][]metricMetadata
+	}{}
+	err = json.Unmarshal(b, &j)
+	if err != nil {
+		return nil, err
+	}
+	if j.Status != "success" {
+		return nil, fmt.Errorf("unexpected status %q", j.Status)
+	}
+	return j.Data, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// batchMarkerLabel is the label used to tell the results of the selectors in
+// a batch query apart.
+const batchMarkerLabel = "prc_batch"
+
+// buildBatchQuery returns a query which counts the results of all given
+// selectors at once. The count of each selector is marked with its index
+// using batchMarkerLabel. Selectors without results do not yield a result.
+func buildBatchQuery(selectors []string) string {
+	branches := make([]string, len(selectors))
+	for i, selector := range selectors {
+		branches[i] = batchBranch(selector, i)
+	}
+	return strings.Join(branches, " or ")
+}
+
+// batchBranch returns the part of a batch query for the given selector.
+func batchBranch(selector string, i int) string {
+	return fmt.Sprintf(`label_replace(count(%s), "%s", "%d", "", "")`, selector, batchMarkerLabel, i)
+}
+
+// splitBatches splits the given selectors into batches of at most size
+// selectors whose batch query does not exceed maxLength. The batches are
+// returned as indexes into selectors. Selectors which exceed maxLength on
+// their own are put into a batch of their own.
+func splitBatches(selectors []string, size, maxLength int) [][]int {
+	var batches [][]int
+	var batch []int
+	length := 0
+	for i, selector := range selectors {
+		// The largest possible index is used to not underestimate the
+		// length.
+		l := len(batchBranch(selector, size)) + len(" or ")
+		if len(batch) > 0 && (len(batch) >= size || length+l > maxLength) {
+			batches = append(batches, batch)
+			batch, length = nil, 0
+		}
+		batch = append(batch, i)
+		length += l
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// getBatchResultCounts counts the results of all given selectors using a
+// single batch query. The returned warnings apply to the whole batch.
+func getBatchResultCounts(c apiClient, selectors []string) ([]uint64, []string, error) {
+	params := url.Values{}
+	params.Add("query", buildBatchQuery(selectors))
+	b, err := c.post("/api/v1/query", params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query request failed: %s", err)
+	}
+
+	j := struct {
+		Status   string
+		Warnings []string
+		Data     struct {
+			Result []struct {
+				Metric map[string]string
+				Value  []interface{}
+			}
+		}
+	}{}
+	err = json.Unmarshal(b, &j)
+	if err != nil {
+		return nil, nil, fmt.Errorf("json parsing failed: %s", err)
+	}
+	if j.Status != "success" {
+		return nil, nil, fmt.Errorf("unexpected status %q in query request", j.Status)
+	}
+	counts := make([]uint64, len(selectors))
+	for _, r := range j.Data.Result {
+		i, err := strconv.Atoi(r.Metric[batchMarkerLabel])
+		if err != nil || i < 0 || i >= len(selectors) {
+			return nil, nil, fmt.Errorf("unexpected batch query result %v", r.Metric)
+		}
+		counts[i], err = strconv.ParseUint(r.Value[1].(string), 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("int conversion failed: %s", err)
+		}
+	}
+	return counts, j.Warnings, nil
+}
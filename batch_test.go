@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestBuildBatchQuery(t *testing.T) {
+	q := buildBatchQuery([]string{`up{job="node"}`, `missing`})
+	e := `label_replace(count(up{job="node"}), "prc_batch", "0", "", "") or label_replace(count(missing), "prc_batch", "1", "", "")`
+	if q != e {
+		t.Errorf("%s != %s", q, e)
+	}
+}
+
+func TestSplitBatches(t *testing.T) {
+	selectors := []string{"a", "b", "c", "d", "e"}
+	r := splitBatches(selectors, 2, 1000)
+	e := [][]int{{0, 1}, {2, 3}, {4}}
+	if !reflect.DeepEqual(r, e) {
+		t.Errorf("%v != %v", r, e)
+	}
+	// Each branch is estimated to be 54 characters long (including the " or "
+	// separator).
+	r = splitBatches(selectors, 10, 110)
+	e = [][]int{{0, 1}, {2, 3}, {4}}
+	if !reflect.DeepEqual(r, e) {
+		t.Errorf("%v != %v", r, e)
+	}
+	r = splitBatches(selectors, 10, 10)
+	e = [][]int{{0}, {1}, {2}, {3}, {4}}
+	if !reflect.DeepEqual(r, e) {
+		t.Errorf("%v != %v", r, e)
+	}
+}
+
+func TestCheckSelectorsBatched(t *testing.T) {
+	var mtx sync.Mutex
+	var queries []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if r.Method != "POST" || r.URL.Path != "/api/v1/query" {
+			t.Errorf("unexpected request: %s %v", r.Method, r.URL)
+		}
+		queries = append(queries, r.PostFormValue("query"))
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"prc_batch":"1"},"value":[1,"3"]}]}}`)
+	}))
+	defer ts.Close()
+
+	size, maxLength := *batchSize, *batchMaxLength
+	defer func() { *batchSize, *batchMaxLength = size, maxLength }()
+	*batchSize, *batchMaxLength = 3, 1000
+	s, err := checkSelectors(newAPIClient(ts.URL, nil), []string{"a", "b", "c", "d", "e", "f"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	e := []selectorStatus{selectorNoResults, selectorOK, selectorNoResults, selectorNoResults, selectorOK, selectorNoResults}
+	if !reflect.DeepEqual(s, e) {
+		t.Errorf("%v != %v", s, e)
+	}
+	if len(queries) != 2 {
+		t.Errorf("%d queries != 2", len(queries))
+	}
+}
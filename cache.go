@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	promql "github.com/prometheus/prometheus/promql/parser"
+	log "github.com/sirupsen/logrus"
+)
+
+// selectorCacheEntry is a cached selector check result.
+type selectorCacheEntry struct {
+	Status selectorStatus
+	Time   time.Time
+}
+
+// selectorCache caches selector check results by selectorCacheKey.
+type selectorCache struct {
+	mtx     sync.Mutex
+	entries map[string]selectorCacheEntry
+}
+
+var resultCache = &selectorCache{entries: map[string]selectorCacheEntry{}}
+
+// get returns the cached status for the given key.
+// Results from previous runs are only cached if they were not older than
+// --cache.ttl when loading them. Results of the current run do not expire.
+func (sc *selectorCache) get(key string) (selectorStatus, bool) {
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+	e, ok := sc.entries[key]
+	return e.Status, ok
+}
+
+// set caches the given status. Inconclusive results are not cached, as they
+// are usually caused by temporary problems.
+func (sc *selectorCache) set(key string, status selectorStatus) {
+	if status == selectorInconclusive {
+		return
+	}
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+	sc.entries[key] = selectorCacheEntry{Status: status, Time: time.Now()}
+}
+
+// load reads cached results from the given file. A missing file is not an
+// error. Expired entries are skipped.
+func (sc *selectorCache) load(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries map[string]selectorCacheEntry
+	err = json.Unmarshal(b, &entries)
+	if err != nil {
+		return err
+	}
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+	for key, e := range entries {
+		if time.Since(e.Time) <= *cacheTTL {
+			sc.entries[key] = e
+		}
+	}
+	log.WithFields(log.Fields{"path": path, "entries": len(sc.entries)}).Debug("Loaded cache")
+	return nil
+}
+
+// save writes all unexpired cached results to the given file.
+func (sc *selectorCache) save(path string) error {
+	sc.mtx.Lock()
+	entries := map[string]selectorCacheEntry{}
+	for key, e := range sc.entries {
+		if time.Since(e.Time) <= *cacheTTL {
+			entries[key] = e
+		}
+	}
+	sc.mtx.Unlock()
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// selectorCacheKey returns the cache key for checking the given selector
+// using the given client. It consists of everything which influences the
+// result (server, tenants, query parameters and existence backend) and the
+// canonical form of the selector.
+func selectorCacheKey(c apiClient, selector string) string {
+	backend := *existenceBackend
+	if backend == "series" {
+		backend = fmt.Sprintf("%s,%s,%s", backend, *seriesWindow, *seriesWindowEnd)
+	}
+	return strings.Join([]string{c.baseURL, c.header.Get("X-Scope-OrgID"), c.params.Encode(), backend, canonicalSelector(selector)}, "|")
+}
+
+// canonicalSelector returns a canonical form of the given selector, so that
+// equivalent selectors yield the same form: matchers are sorted and regexp
+// matchers with literal values are replaced by (in)equality matchers.
+// Selectors which cannot be parsed are returned unchanged.
+func canonicalSelector(selector string) string {
+	matchers, err := promql.ParseMetricSelector(selector)
+	if err != nil {
+		log.WithFields(log.Fields{"selector": selector, "err": err}).Debug("Metric selector parsing failed, not canonicalizing")
+		return selector
+	}
+	parts := make([]string, len(matchers))
+	for i, m := range matchers {
+		t := m.Type
+		if isLiteralRegexp(m.Value) {
+			switch t {
+			case labels.MatchRegexp:
+				t = labels.MatchEqual
+			case labels.MatchNotRegexp:
+				t = labels.MatchNotEqual
+			}
+		}
+		parts[i] = (&labels.Matcher{Type: t, Name: m.Name, Value: m.Value}).String()
+	}
+	sort.Strings(parts)
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// isLiteralRegexp returns true if the given regexp only matches the value
+// itself.
+func isLiteralRegexp(value string) bool {
+	re, err := syntax.Parse(value, syntax.Perl)
+	if err != nil {
+		return false
+	}
+	return re.Op == syntax.OpLiteral && re.Flags&syntax.FoldCase == 0 && string(re.Rune) == value ||
+		re.Op == syntax.OpEmptyMatch && value == ""
+}
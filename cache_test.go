@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCanonicalSelector(t *testing.T) {
+	c := map[string]string{
+		`up{job="node",instance="a"}`:  `{__name__="up",instance="a",job="node"}`,
+		`up{instance="a",job=~"node"}`: `{__name__="up",instance="a",job="node"}`,
+		`{__name__="up",job="node"}`:   `{__name__="up",job="node"}`,
+		`up{job!~"node"}`:              `{__name__="up",job!="node"}`,
+		`up{job=~"node|prometheus"}`:   `{__name__="up",job=~"node|prometheus"}`,
+		`up{job=~"node.*"}`:            `{__name__="up",job=~"node.*"}`,
+		`up{job=~"(?i)node"}`:          `{__name__="up",job=~"(?i)node"}`,
+		`up{job=~""}`:                  `{__name__="up",job=""}`,
+		`up{job=~"a\\.b"}`:             `{__name__="up",job=~"a\\.b"}`,
+	}
+	for selector, e := range c {
+		r := canonicalSelector(selector)
+		if r != e {
+			t.Errorf("%s: %s != %s", selector, r, e)
+		}
+	}
+}
+
+func TestSelectorCachePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prc")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.json")
+
+	ttl := *cacheTTL
+	defer func() { *cacheTTL = ttl }()
+	*cacheTTL = time.Minute
+	sc := &selectorCache{entries: map[string]selectorCacheEntry{
+		"fresh": {Status: selectorNoResults, Time: time.Now()},
+		"old":   {Status: selectorOK, Time: time.Now().Add(-2 * time.Minute)},
+	}}
+	sc.set("inconclusive", selectorInconclusive)
+	err = sc.save(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	loaded := &selectorCache{entries: map[string]selectorCacheEntry{}}
+	err = loaded.load(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if s, ok := loaded.get("fresh"); !ok || s != selectorNoResults {
+		t.Errorf("fresh: %v, %v", s, ok)
+	}
+	for _, key := range []string{"old", "inconclusive"} {
+		if _, ok := loaded.get(key); ok {
+			t.Errorf("%s: unexpectedly cached", key)
+		}
+	}
+	err = loaded.load(filepath.Join(dir, "missing.json"))
+	if err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestCheckSelectorsDeduplication(t *testing.T) {
+	queries := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+	}))
+	defer ts.Close()
+
+	c := newAPIClient(ts.URL, nil)
+	checkSelectors(c, []string{`up{a="1",b="2"}`, `up{b="2",a=~"1"}`})
+	checkSelectors(c, []string{`up{a="1",b=~"2"}`})
+	if queries != 1 {
+		t.Errorf("%d queries != 1", queries)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// getRuleGroupsFromExpressions returns a rule group containing the given
+// expressions or, if there are none, the expressions read from the given file
+// or stdin.
+func getRuleGroupsFromExpressions(exprs []string, file string) []ruleGroup {
+	if len(exprs) > 0 {
+		g := ruleGroup{Name: "check-expr", File: "<args>"}
+		for i, expr := range exprs {
+			g.Rules = append(g.Rules, rule{Name: fmt.Sprintf("expr %d", i+1), Query: expr})
+		}
+		return []ruleGroup{g}
+	}
+	var r io.Reader = os.Stdin
+	name := "<stdin>"
+	if file != "" && file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			log.WithFields(log.Fields{"file": file, "err": err}).Fatal("Expression file reading failed")
+		}
+		defer f.Close()
+		r, name = f, file
+	}
+	g, err := readExpressions(r, name)
+	if err != nil {
+		log.WithFields(log.Fields{"file": name, "err": err}).Fatal("Expression reading failed")
+	}
+	return []ruleGroup{g}
+}
+
+// readExpressions reads one expression per line from the given reader.
+// Empty lines and lines starting with # are skipped.
+func readExpressions(r io.Reader, name string) (ruleGroup, error) {
+	g := ruleGroup{Name: "check-expr", File: name}
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		expr := strings.TrimSpace(s.Text())
+		if expr == "" || strings.HasPrefix(expr, "#") {
+			continue
+		}
+		g.Rules = append(g.Rules, rule{Name: fmt.Sprintf("expr %d", len(g.Rules)+1), Query: expr, Line: line})
+	}
+	return g, s.Err()
+}
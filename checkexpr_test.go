@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadExpressions(t *testing.T) {
+	in := "# comment\nup{job=\"node\"}\n\n  rate(foo_total[5m]) > 0  \n"
+	g, err := readExpressions(strings.NewReader(in), "exprs.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	e := ruleGroup{
+		Name: "check-expr",
+		File: "exprs.txt",
+		Rules: []rule{
+			{Name: "expr 1", Query: "up{job=\"node\"}", Line: 2},
+			{Name: "expr 2", Query: "rate(foo_total[5m]) > 0", Line: 4},
+		},
+	}
+	if !reflect.DeepEqual(g, e) {
+		t.Errorf("%v != %v", g, e)
+	}
+}
+
+func TestCheckExpressionsParseError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+	}))
+	defer ts.Close()
+
+	g, err := readExpressions(strings.NewReader("missing_a\nrate(foo[5m]\nmissing_b\n"), "<stdin>")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	r, err := checkRules(ts.URL, []ruleGroup{g})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(r) != 3 {
+		t.Fatalf("unexpected results: %+v", r)
+	}
+	if r[1].Line != 2 || r[1].ParseError == "" {
+		t.Errorf("parse error of line 2 not reported: %+v", r[1])
+	}
+	if !reflect.DeepEqual(r[0].NoResultSelectors, []string{"missing_a"}) || !reflect.DeepEqual(r[2].NoResultSelectors, []string{"missing_b"}) {
+		t.Errorf("other lines not checked: %+v", r)
+	}
+}
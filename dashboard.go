@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	promql "github.com/prometheus/prometheus/promql/parser"
+	log "github.com/sirupsen/logrus"
+)
+
+// grafanaDashboard is the part of the Grafana dashboard JSON model which is
+// relevant for extracting PromQL queries.
+type grafanaDashboard struct {
+	Title  string
+	Panels []grafanaPanel
+	// Rows are only used by dashboards using the pre-5.0 schema.
+	Rows []struct {
+		Title  string
+		Panels []grafanaPanel
+	}
+	Templating struct {
+		List []grafanaVariable
+	}
+}
+
+// grafanaPanel is a dashboard panel. Panels of type row contain their
+// children in Panels if they are collapsed.
+type grafanaPanel struct {
+	Title      string
+	Datasource json.RawMessage
+	Panels     []grafanaPanel
+	Targets    []struct {
+		RefID      string `json:"refId"`
+		Expr       string
+		Datasource json.RawMessage
+	}
+}
+
+// grafanaVariable is a dashboard template variable. Query is either a plain
+// string or an object with a query field, depending on the Grafana version.
+type grafanaVariable struct {
+	Name     string
+	Type     string
+	Query    json.RawMessage
+	AllValue string
+	Current  struct {
+		Value json.RawMessage
+	}
+}
+
+// dashboardVariable is the value a template variable is replaced with.
+// isRegex is set if the value already is a regexp, e.g. for multiple values
+// or the All value.
+type dashboardVariable struct {
+	value   string
+	isRegex bool
+}
+
+// grafanaBuiltinVariables contains defaults for Grafana's global variables.
+// They can be overridden via --dashboard.variable.
+var grafanaBuiltinVariables = map[string]dashboardVariable{
+	"__rate_interval": {value: "5m"},
+	"__interval":      {value: "1m"},
+	"__interval_ms":   {value: "60000"},
+	"__range":         {value: "1h"},
+	"__range_s":       {value: "3600"},
+	"__range_ms":      {value: "3600000"},
+}
+
+var (
+	// dashboardVariableRe matches $var, ${var}, ${var:format} and [[var]].
+	dashboardVariableRe = regexp.MustCompile(`\$(\w+)|\$\{(\w+)(?::(\w+))?\}|\[\[(\w+)(?::(\w+))?\]\]`)
+	labelValuesRe       = regexp.MustCompile(`^\s*label_values\(\s*(?:(.+?)\s*,\s*)?([a-zA-Z_][a-zA-Z0-9_]*)\s*\)\s*$`)
+	queryResultRe       = regexp.MustCompile(`^\s*query_result\((.+)\)\s*$`)
+)
+
+// getRuleGroupsFromDashboards reads all Grafana dashboard JSON files
+// matching the given glob patterns and returns a rule group for each panel.
+// Template variables are fetched from the given server if enabled.
+func getRuleGroupsFromDashboards(patterns []string, server string) []ruleGroup {
+	var c *apiClient
+	if *dashboardFetchVariables {
+		client := newAPIClient(server, nil)
+		c = &client
+	}
+	var groups []ruleGroup
+	for _, path := range globFiles(patterns) {
+		log.WithFields(log.Fields{"path": path}).Debug("Reading dashboard file")
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.WithFields(log.Fields{"path": path, "err": err}).Fatal("Dashboard file reading failed")
+		}
+		g, err := parseDashboard(b, *dashboardVariables, c)
+		if err != nil {
+			log.WithFields(log.Fields{"path": path, "err": err}).Fatal("Dashboard file parsing failed")
+		}
+		groups = append(groups, g...)
+	}
+	return groups
+}
+
+// parseDashboard parses the given dashboard JSON (either the plain dashboard
+// model or an export wrapping it in a dashboard field) and returns a rule
+// group for each panel with PromQL targets and one for the templating
+// queries.
+// Template variables are replaced using overrides, live values (if c is
+// non-nil) or the dashboard's current values, in this order.
+// The resulting groups are labelled with the dashboard title.
+func parseDashboard(b []byte, overrides map[string]string, c *apiClient) ([]ruleGroup, error) {
+	var wrapper struct {
+		Dashboard *grafanaDashboard
+	}
+	err := json.Unmarshal(b, &wrapper)
+	if err != nil {
+		return nil, err
+	}
+	d := wrapper.Dashboard
+	if d == nil {
+		d = &grafanaDashboard{}
+		err = json.Unmarshal(b, d)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	vars := resolveDashboardVariables(d, overrides, c)
+	var groups []ruleGroup
+	templating := ruleGroup{Name: "Templating", File: d.Title}
+	for _, v := range d.Templating.List {
+		if v.Type != "query" {
+			continue
+		}
+		query := interpolateDashboardVariables(extractDashboardVariableQuery(v), vars)
+		if m := labelValuesRe.FindStringSubmatch(query); m != nil && m[1] != "" {
+			query = m[1]
+		} else if m := queryResultRe.FindStringSubmatch(query); m != nil {
+			query = m[1]
+		} else {
+			continue
+		}
+		templating.Rules = appendDashboardRule(templating.Rules, d.Title, "$"+v.Name, query)
+	}
+	if len(templating.Rules) > 0 {
+		groups = append(groups, templating)
+	}
+
+	panels := d.Panels
+	for _, r := range d.Rows {
+		panels = append(panels, grafanaPanel{Title: r.Title, Panels: r.Panels})
+	}
+	var walk func(prefix string, panels []grafanaPanel)
+	walk = func(prefix string, panels []grafanaPanel) {
+		for _, p := range panels {
+			title := prefix + interpolateDashboardVariables(p.Title, vars)
+			g := ruleGroup{Name: title, File: d.Title}
+			for _, t := range p.Targets {
+				ds := t.Datasource
+				if len(ds) == 0 || string(ds) == "null" {
+					ds = p.Datasource
+				}
+				if t.Expr == "" || !isPrometheusDatasource(ds) {
+					continue
+				}
+				g.Rules = appendDashboardRule(g.Rules, d.Title, t.RefID, interpolateDashboardVariables(t.Expr, vars))
+			}
+			if len(g.Rules) > 0 {
+				groups = append(groups, g)
+			}
+			walk(title+" / ", p.Panels)
+		}
+	}
+	walk("", panels)
+	return groups, nil
+}
+
+// appendDashboardRule appends a rule for the given (already interpolated)
+// query unless it cannot be parsed, e.g. due to unresolved variables.
+func appendDashboardRule(rules []rule, dashboard, name, query string) []rule {
+	_, err := promql.ParseExpr(query)
+	if err != nil {
+		log.WithFields(log.Fields{"dashboard": dashboard, "name": name, "query": query, "err": err}).Warn("Skipping unparsable dashboard query")
+		return rules
+	}
+	return append(rules, rule{Name: name, Query: query, Type: "dashboard"})
+}
+
+// isPrometheusDatasource returns false if the given datasource reference
+// explicitly refers to a datasource of another type. Datasources referenced
+// by name only are assumed to be Prometheus datasources.
+func isPrometheusDatasource(raw json.RawMessage) bool {
+	var ds struct {
+		Type string
+	}
+	if json.Unmarshal(raw, &ds) != nil || ds.Type == "" {
+		return true
+	}
+	return ds.Type == "prometheus"
+}
+
+// extractDashboardVariableQuery returns the query of a template variable.
+func extractDashboardVariableQuery(v grafanaVariable) string {
+	var query string
+	if json.Unmarshal(v.Query, &query) == nil {
+		return query
+	}
+	var q struct {
+		Query string
+	}
+	if json.Unmarshal(v.Query, &q) == nil {
+		return q.Query
+	}
+	return ""
+}
+
+// resolveDashboardVariables determines the values of all template variables
+// of the given dashboard.
+func resolveDashboardVariables(d *grafanaDashboard, overrides map[string]string, c *apiClient) map[string]dashboardVariable {
+	vars := map[string]dashboardVariable{}
+	for name, v := range grafanaBuiltinVariables {
+		vars[name] = v
+	}
+	for name, value := range overrides {
+		vars[name] = dashboardVariable{value: value}
+	}
+	for _, v := range d.Templating.List {
+		if _, ok := overrides[v.Name]; ok {
+			continue
+		}
+		if c != nil && v.Type == "query" {
+			value, err := fetchDashboardVariableValue(*c, interpolateDashboardVariables(extractDashboardVariableQuery(v), vars))
+			if err != nil {
+				log.WithFields(log.Fields{"dashboard": d.Title, "variable": v.Name, "err": err}).Warn("Failed to fetch variable value")
+			} else if value != "" {
+				vars[v.Name] = dashboardVariable{value: value}
+				continue
+			}
+		}
+		var value string
+		var values []string
+		if json.Unmarshal(v.Current.Value, &value) == nil {
+			values = []string{value}
+		} else if json.Unmarshal(v.Current.Value, &values) != nil {
+			continue
+		}
+		if len(values) == 1 && values[0] == "$__all" {
+			all := v.AllValue
+			if all == "" {
+				all = ".*"
+			}
+			vars[v.Name] = dashboardVariable{value: all, isRegex: true}
+		} else if len(values) == 1 {
+			vars[v.Name] = dashboardVariable{value: values[0]}
+		} else if len(values) > 1 {
+			vars[v.Name] = dashboardVariable{value: "(" + strings.Join(escapeDashboardVariableValues(values), "|") + ")", isRegex: true}
+		}
+	}
+	return vars
+}
+
+// fetchDashboardVariableValue resolves a label_values() variable query
+// against the Prometheus API and returns its first value.
+// Other queries are not supported and yield an empty value.
+func fetchDashboardVariableValue(c apiClient, query string) (string, error) {
+	m := labelValuesRe.FindStringSubmatch(query)
+	if m == nil {
+		return "", nil
+	}
+	var match []string
+	if m[1] != "" {
+		match = []string{m[1]}
+	}
+	values, err := getLabelValues(c, m[2], match)
+	if err != nil || len(values) == 0 {
+		return "", err
+	}
+	return values[0], nil
+}
+
+// escapeDashboardVariableValues escapes the given values for use in a
+// regexp within a PromQL string literal.
+func escapeDashboardVariableValues(values []string) []string {
+	escaped := make([]string, len(values))
+	for i, v := range values {
+		escaped[i] = strings.ReplaceAll(regexp.QuoteMeta(v), `\`, `\\`)
+	}
+	return escaped
+}
+
+// interpolateDashboardVariables replaces all known template variables in the
+// given string. Unknown variables are kept as they are.
+func interpolateDashboardVariables(s string, vars map[string]dashboardVariable) string {
+	return dashboardVariableRe.ReplaceAllStringFunc(s, func(m string) string {
+		sm := dashboardVariableRe.FindStringSubmatch(m)
+		name, format := sm[1]+sm[2]+sm[4], sm[3]+sm[5]
+		v, ok := vars[name]
+		if !ok {
+			return m
+		}
+		if format == "regex" && !v.isRegex {
+			return escapeDashboardVariableValues([]string{v.value})[0]
+		}
+		return v.value
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInterpolateDashboardVariables(t *testing.T) {
+	vars := map[string]dashboardVariable{
+		"__rate_interval": {value: "5m"},
+		"job":             {value: "node.example"},
+		"instance":        {value: "(a|b)", isRegex: true},
+	}
+	c := map[string]string{
+		`rate(up{job="$job"}[$__rate_interval])`:  `rate(up{job="node.example"}[5m])`,
+		`up{job=~"${job:regex}"}`:                 `up{job=~"node\\.example"}`,
+		`up{instance=~"[[instance]]",x="$other"}`: `up{instance=~"(a|b)",x="$other"}`,
+	}
+	for i, e := range c {
+		o := interpolateDashboardVariables(i, vars)
+		if o != e {
+			t.Errorf("%s != %s", o, e)
+		}
+	}
+}
+
+func TestParseDashboard(t *testing.T) {
+	d := `{
+  "title": "Nodes",
+  "templating": {"list": [
+    {"name": "job", "type": "query", "query": "label_values(up, job)", "current": {"value": "node"}},
+    {"name": "instance", "type": "query", "query": {"query": "label_values(up{job=\"$job\"}, instance)"}, "current": {"value": ["a", "b"]}},
+    {"name": "interval", "type": "interval", "current": {"value": "1m"}}
+  ]},
+  "panels": [
+    {"title": "Load", "type": "timeseries", "targets": [{"refId": "A", "expr": "node_load1{job=\"$job\",instance=~\"$instance\"}"}]},
+    {"title": "Logs", "type": "logs", "datasource": {"type": "loki", "uid": "loki"}, "targets": [{"refId": "A", "expr": "{job=\"$job\"}"}]},
+    {"title": "Details", "type": "row", "panels": [
+      {"title": "CPU $job", "targets": [{"refId": "B", "expr": "rate(node_cpu_seconds_total[$__rate_interval])"}]}
+    ]}
+  ]
+}`
+	g, err := parseDashboard([]byte(d), map[string]string{"__rate_interval": "2m"}, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	e := []ruleGroup{
+		{
+			Name: "Templating",
+			File: "Nodes",
+			Rules: []rule{
+				{Name: "$job", Query: "up", Type: "dashboard"},
+				{Name: "$instance", Query: "up{job=\"node\"}", Type: "dashboard"},
+			},
+		},
+		{
+			Name:  "Load",
+			File:  "Nodes",
+			Rules: []rule{{Name: "A", Query: "node_load1{job=\"node\",instance=~\"(a|b)\"}", Type: "dashboard"}},
+		},
+		{
+			Name:  "Details / CPU node",
+			File:  "Nodes",
+			Rules: []rule{{Name: "B", Query: "rate(node_cpu_seconds_total[2m])", Type: "dashboard"}},
+		},
+	}
+	if !reflect.DeepEqual(g, e) {
+		t.Errorf("%v != %v", g, e)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// groupEvaluationItem describes the evaluation cost of a rule group.
+// Times are given in seconds. IntervalShare is the fraction of the interval
+// which is taken up by the group's evaluation.
+type groupEvaluationItem struct {
+	Server         string `json:",omitempty"`
+	Tenant         string `json:",omitempty"`
+	File           string
+	Group          string
+	Interval       float64
+	EvaluationTime float64
+	IntervalShare  float64
+	LastEvaluation time.Time
+	Slow           bool
+	Rules          []ruleEvaluationItem
+}
+
+// ruleEvaluationItem describes the evaluation cost of a single rule.
+// GroupShare is the fraction of the group's evaluation time which is taken up
+// by this rule.
+type ruleEvaluationItem struct {
+	Name           string
+	EvaluationTime float64
+	GroupShare     float64
+}
+
+// getEvaluationReport retrieves the rule groups of all given servers and
+// ranks them by evaluation time. Groups without evaluation state (e.g. from
+// rule files) are skipped.
+// Returns true as second value if any server could not be checked.
+func getEvaluationReport(servers []string, getGroups func(server string) ([]ruleGroup, error)) ([]groupEvaluationItem, bool) {
+	reports := make([][]groupEvaluationItem, len(servers))
+	failed := forEachServer(servers, func(i int, server string) error {
+		groups, err := getGroups(server)
+		if err != nil {
+			return err
+		}
+		reports[i] = rankEvaluationTimes(groups, *evaluationReportThreshold)
+		if len(servers) > 1 {
+			for j := range reports[i] {
+				reports[i][j].Server = server
+			}
+		}
+		return nil
+	})
+	var report []groupEvaluationItem
+	for _, r := range reports {
+		report = append(report, r...)
+	}
+	sort.SliceStable(report, func(i, j int) bool {
+		return report[i].EvaluationTime > report[j].EvaluationTime
+	})
+	return report, failed
+}
+
+// rankEvaluationTimes returns the evaluation cost of the given groups and
+// their rules, most expensive first. Groups whose evaluation time exceeds the
+// given fraction of their interval are marked as slow.
+func rankEvaluationTimes(groups []ruleGroup, threshold float64) []groupEvaluationItem {
+	var items []groupEvaluationItem
+	for _, g := range groups {
+		if g.Interval <= 0 || g.LastEvaluation.IsZero() {
+			continue
+		}
+		item := groupEvaluationItem{
+			Tenant:         g.Tenant,
+			File:           g.File,
+			Group:          g.Name,
+			Interval:       g.Interval,
+			EvaluationTime: g.EvaluationTime,
+			IntervalShare:  g.EvaluationTime / g.Interval,
+			LastEvaluation: g.LastEvaluation,
+		}
+		item.Slow = item.IntervalShare > threshold
+		for _, r := range g.Rules {
+			ri := ruleEvaluationItem{Name: r.Name, EvaluationTime: r.EvaluationTime}
+			if g.EvaluationTime > 0 {
+				ri.GroupShare = r.EvaluationTime / g.EvaluationTime
+			}
+			item.Rules = append(item.Rules, ri)
+		}
+		sort.SliceStable(item.Rules, func(i, j int) bool {
+			return item.Rules[i].EvaluationTime > item.Rules[j].EvaluationTime
+		})
+		items = append(items, item)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].EvaluationTime > items[j].EvaluationTime
+	})
+	return items
+}
+
+// hasSlowGroups returns true if any of the given groups is slow.
+func hasSlowGroups(report []groupEvaluationItem) bool {
+	for _, g := range report {
+		if g.Slow {
+			return true
+		}
+	}
+	return false
+}
+
+// printEvaluationReport prints the given report in the configured output
+// format.
+func printEvaluationReport(report []groupEvaluationItem) {
+	switch *outputFormat {
+	case "human":
+		for _, g := range report {
+			file := g.File
+			if g.Tenant != "" {
+				file = fmt.Sprintf("%s -> %s", g.Tenant, file)
+			}
+			if g.Server != "" {
+				file = fmt.Sprintf("%s -> %s", g.Server, file)
+			}
+			slow := ""
+			if g.Slow {
+				slow = " [SLOW]"
+			}
+			fmt.Printf("%s -> %s%s\n", file, g.Group, slow)
+			fmt.Printf("  Evaluation time: %s of %s interval (%.1f%%), last evaluation: %s\n", secondsToDuration(g.EvaluationTime), secondsToDuration(g.Interval), g.IntervalShare*100, g.LastEvaluation.Format(time.RFC3339))
+			for _, r := range g.Rules {
+				fmt.Printf("    - %s (%.1f%%) %s\n", secondsToDuration(r.EvaluationTime), r.GroupShare*100, r.Name)
+			}
+			fmt.Printf("\n")
+		}
+	case "csv":
+		fmt.Printf("File;Group;Interval;Group evaluation time;Interval share;Slow;Name;Rule evaluation time;Group share;Tenant;Server\n")
+		for _, g := range report {
+			for _, r := range g.Rules {
+				fmt.Printf("%s;%s;%g;%g;%g;%t;%s;%g;%g;%s;%s\n", g.File, g.Group, g.Interval, g.EvaluationTime, g.IntervalShare, g.Slow, r.Name, r.EvaluationTime, r.GroupShare, g.Tenant, g.Server)
+			}
+		}
+	case "json":
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Fatal("failed to marshal json")
+		}
+		fmt.Println(string(b))
+	default:
+		log.WithFields(log.Fields{"outputFormat": *outputFormat}).Fatal("unsupported output format")
+	}
+}
+
+// secondsToDuration converts the given number of seconds into a
+// time.Duration for printing.
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Microsecond)
+}
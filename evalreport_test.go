@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRankEvaluationTimes(t *testing.T) {
+	last := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	groups := []ruleGroup{
+		{
+			Name: "fast", File: "a.yml", Interval: 60, EvaluationTime: 1, LastEvaluation: last,
+			Rules: []rule{{Name: "a", EvaluationTime: 0.25}, {Name: "b", EvaluationTime: 0.75}},
+		},
+		{
+			Name: "from-file", File: "b.yml",
+			Rules: []rule{{Name: "c"}},
+		},
+		{
+			Name: "slow", File: "c.yml", Interval: 10, EvaluationTime: 9, LastEvaluation: last,
+			Rules: []rule{{Name: "d", EvaluationTime: 9}},
+		},
+	}
+	e := []groupEvaluationItem{
+		{
+			File: "c.yml", Group: "slow", Interval: 10, EvaluationTime: 9, IntervalShare: 0.9, LastEvaluation: last, Slow: true,
+			Rules: []ruleEvaluationItem{{Name: "d", EvaluationTime: 9, GroupShare: 1}},
+		},
+		{
+			File: "a.yml", Group: "fast", Interval: 60, EvaluationTime: 1, IntervalShare: 1.0 / 60, LastEvaluation: last,
+			Rules: []ruleEvaluationItem{{Name: "b", EvaluationTime: 0.75, GroupShare: 0.75}, {Name: "a", EvaluationTime: 0.25, GroupShare: 0.25}},
+		},
+	}
+	r := rankEvaluationTimes(groups, 0.8)
+	if !reflect.DeepEqual(r, e) {
+		t.Errorf("%v != %v", r, e)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/prometheus/model/labels"
+	promql "github.com/prometheus/prometheus/promql/parser"
+	log "github.com/sirupsen/logrus"
+)
+
+// labelValuesEntry is a cached set of values of a label of a metric or the
+// error which occurred while retrieving it. Concurrent users of the same
+// entry wait for the first retrieval.
+type labelValuesEntry struct {
+	once   sync.Once
+	values map[string]bool
+	err    error
+}
+
+var (
+	labelValuesCacheMtx sync.Mutex
+	labelValuesCache    = map[string]*labelValuesEntry{}
+)
+
+// findDeadExclusions checks the negative matchers (!= and !~) of all
+// selectors of the given query and returns the excluded values which no
+// series of the selector's metric has, keyed by selector. Such exclusions
+// are dead code. The values are returned as equality matchers, e.g.
+// fstype="rpc_pipefs" for fstype!~"tmpfs|rpc_pipefs".
+// Regexp alternatives are only checked if they can be expanded.
+func findDeadExclusions(c apiClient, query string) (map[string][]string, error) {
+	selectors, err := getSelectors(query)
+	if err != nil {
+		return nil, err
+	}
+	dead := map[string][]string{}
+	for _, selector := range selectors {
+		matchers, err := promql.ParseMetricSelector(selector)
+		if err != nil {
+			return nil, fmt.Errorf("metric selector parsing failed: %s", err)
+		}
+		metric := ""
+		for _, m := range matchers {
+			if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+				metric = m.Value
+			}
+		}
+		if metric == "" || ignoreMatchers(matchers) || isSelectorIgnored(selector) {
+			continue
+		}
+		if *prefetchMetricNames && !metricExists(c, matchers) {
+			// The whole selector is reported already.
+			continue
+		}
+		for _, m := range matchers {
+			var excluded []string
+			switch m.Type {
+			case labels.MatchNotEqual:
+				excluded = []string{m.Value}
+			case labels.MatchNotRegexp:
+				excluded = expandRegexpAlternatives(m.Value, *expandMax)
+			default:
+				continue
+			}
+			if len(excluded) == 0 {
+				continue
+			}
+			values, err := getExistingLabelValues(c, metric, m.Name)
+			if err != nil {
+				log.WithFields(log.Fields{"selector": selector, "label": m.Name, "err": err}).Warn("Failed to retrieve label values")
+				continue
+			}
+			for _, v := range excluded {
+				// Excluding the empty value means that the label
+				// has to exist, which is not an exclusion of a
+				// specific value.
+				if v != "" && !values[v] {
+					dead[selector] = append(dead[selector], (&labels.Matcher{Type: labels.MatchEqual, Name: m.Name, Value: v}).String())
+				}
+			}
+		}
+	}
+	if len(dead) == 0 {
+		return nil, nil
+	}
+	return dead, nil
+}
+
+// getExistingLabelValues returns the values the given label has for any
+// series of the given metric. Values are retrieved only once per run.
+func getExistingLabelValues(c apiClient, metric, label string) (map[string]bool, error) {
+	key := c.baseURL + "|" + c.header.Get("X-Scope-OrgID") + "|" + metric + "|" + label
+	labelValuesCacheMtx.Lock()
+	e, ok := labelValuesCache[key]
+	if !ok {
+		e = &labelValuesEntry{}
+		labelValuesCache[key] = e
+	}
+	labelValuesCacheMtx.Unlock()
+	e.once.Do(func() {
+		values, err := getLabelValues(c, label, []string{metric})
+		if err != nil {
+			e.err = err
+			return
+		}
+		e.values = map[string]bool{}
+		for _, v := range values {
+			e.values[v] = true
+		}
+	})
+	return e.values, e.err
+}
+
+// sortedKeys returns the keys of the given map in sorted order.
+func sortedKeys(m map[string][]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
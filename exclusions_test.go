@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestFindDeadExclusions(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/api/v1/label/fstype/values":
+			fmt.Fprint(w, `{"status":"success","data":["ext4","tmpfs"]}`)
+		case "/api/v1/label/mountpoint/values":
+			fmt.Fprint(w, `{"status":"success","data":["/","/run"]}`)
+		default:
+			t.Errorf("unexpected request: %v", r.URL)
+		}
+	}))
+	defer ts.Close()
+
+	max := *expandMax
+	defer func() { *expandMax = max }()
+	*expandMax = 256
+	query := `node_filesystem_free_bytes{fstype!~"tmpfs|rpc_pipefs|nfs.*",mountpoint!="/boot",mountpoint!=""} / node_filesystem_size_bytes{fstype!="tmpfs"} and on() up{job!~"(?i)node"}`
+	r, err := findDeadExclusions(newAPIClient(ts.URL, nil), query)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	e := map[string][]string{
+		`node_filesystem_free_bytes{fstype!~"tmpfs|rpc_pipefs|nfs.*",mountpoint!="",mountpoint!="/boot"}`: {`fstype="rpc_pipefs"`, `mountpoint="/boot"`},
+	}
+	if !reflect.DeepEqual(r, e) {
+		t.Errorf("%v != %v", r, e)
+	}
+	// Label values are retrieved once per metric and label.
+	if requests != 3 {
+		t.Errorf("%d requests != 3", requests)
+	}
+}
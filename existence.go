@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// selectorStatus is the outcome of checking a single selector.
+type selectorStatus int
+
+const (
+	// selectorOK means that the selector currently yields results.
+	selectorOK selectorStatus = iota
+	// selectorNoResults means that the selector yields no results (and
+	// has not yielded any within the lookback window, if applicable).
+	selectorNoResults
+	// selectorInconclusive means that the selector yielded no results,
+	// but the server reported a partial response.
+	selectorInconclusive
+	// selectorNotCurrent means that the selector currently yields no
+	// results, but did so within the lookback window.
+	selectorNotCurrent
+)
+
+// checkSelector determines whether the given selector yields results using
+// the configured existence backend.
+func checkSelector(c apiClient, selector string) (selectorStatus, error) {
+	statuses, err := checkSelectors(c, []string{selector})
+	if err != nil {
+		return selectorNoResults, err
+	}
+	return statuses[0], nil
+}
+
+// checkSelectors determines whether the given selectors yield results using
+// the configured existence backend.
+// Each distinct selector is only checked once. Results are cached for the
+// whole run (and possibly across runs, see --cache.file).
+func checkSelectors(c apiClient, selectors []string) ([]selectorStatus, error) {
+	statuses := make([]selectorStatus, len(selectors))
+	keys := make([]string, len(selectors))
+	var unique []string
+	uniqueIndex := map[string]int{}
+	for i, selector := range selectors {
+		keys[i] = selectorCacheKey(c, selector)
+		if status, ok := resultCache.get(keys[i]); ok {
+			log.WithFields(log.Fields{"selector": selector}).Debug("Using cached result")
+			stats.add(&stats.cacheHits, 1)
+			statuses[i] = status
+			continue
+		}
+		if _, ok := uniqueIndex[keys[i]]; ok {
+			stats.add(&stats.cacheHits, 1)
+			continue
+		}
+		uniqueIndex[keys[i]] = len(unique)
+		unique = append(unique, selector)
+	}
+	uniqueStatuses, err := querySelectors(c, unique)
+	if err != nil {
+		return nil, err
+	}
+	for key, i := range uniqueIndex {
+		resultCache.set(key, uniqueStatuses[i])
+	}
+	for i, key := range keys {
+		if j, ok := uniqueIndex[key]; ok {
+			statuses[i] = uniqueStatuses[j]
+		}
+	}
+	return statuses, nil
+}
+
+// querySelectors determines whether the given selectors yield results using
+// the configured existence backend without using the cache.
+// Instant count() queries are used first (batched if enabled), unless a
+// selector can be checked using the series index. For selectors without
+// results, the series API is used to check whether there have been matching
+// series within the lookback window if the series backend is enabled.
+// The first error of any query is returned.
+func querySelectors(c apiClient, selectors []string) ([]selectorStatus, error) {
+	statuses := make([]selectorStatus, len(selectors))
+	var pending []int
+	for i, selector := range selectors {
+		statuses[i] = selectorNoResults
+		if *seriesIndexEnabled {
+			found, indexed := lookupSeriesIndex(c, selector)
+			if found {
+				statuses[i] = selectorOK
+			}
+			if indexed {
+				continue
+			}
+		}
+		pending = append(pending, i)
+	}
+
+	var batches [][]int
+	if *batchSize > 1 && len(pending) > 1 {
+		var batchSelectors []string
+		for _, i := range pending {
+			batchSelectors = append(batchSelectors, selectors[i])
+		}
+		for _, batch := range splitBatches(batchSelectors, *batchSize, *batchMaxLength) {
+			for j := range batch {
+				batch[j] = pending[batch[j]]
+			}
+			batches = append(batches, batch)
+		}
+	} else {
+		for _, i := range pending {
+			batches = append(batches, []int{i})
+		}
+	}
+	errs := make([]error, len(selectors))
+	forEachIndex(len(batches), *queryConcurrency, func(b int) {
+		batch := batches[b]
+		var counts []uint64
+		var warnings []string
+		var err error
+		if len(batch) == 1 {
+			var count uint64
+			count, warnings, err = getResultCount(c, selectors[batch[0]])
+			counts = []uint64{count}
+		} else {
+			var batchSelectors []string
+			for _, i := range batch {
+				batchSelectors = append(batchSelectors, selectors[i])
+			}
+			counts, warnings, err = getBatchResultCounts(c, batchSelectors)
+		}
+		if err != nil {
+			errs[batch[0]] = err
+			return
+		}
+		for j, i := range batch {
+			if counts[j] > 0 {
+				statuses[i] = selectorOK
+			} else if isPartialResponse(c, warnings) {
+				log.WithFields(log.Fields{"selector": selectors[i], "warnings": warnings}).Debug("Inconclusive result")
+				statuses[i] = selectorInconclusive
+			}
+		}
+	})
+
+	if err := firstError(errs); err != nil {
+		return nil, err
+	}
+
+	if *existenceBackend != "series" {
+		return statuses, nil
+	}
+	forEachIndex(len(selectors), *queryConcurrency, func(i int) {
+		if statuses[i] != selectorNoResults {
+			return
+		}
+		found, err := hasSeriesInWindow(c, selectors[i])
+		if err != nil {
+			errs[i] = err
+		} else if found {
+			statuses[i] = selectorNotCurrent
+		}
+	})
+	if err := firstError(errs); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// firstError returns the first non-nil error of the given ones.
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isPartialResponse returns true if the given query warnings indicate a
+// partial response. Thanos returns partial responses unless
+// partial_response=false is set, while other warnings (e.g. PromQL
+// annotations) do not affect the completeness of the result.
+func isPartialResponse(c apiClient, warnings []string) bool {
+	if c.params.Get("partial_response") == "false" {
+		return false
+	}
+	for _, w := range warnings {
+		if !strings.HasPrefix(w, "PromQL ") {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSeriesInWindow returns true if the series API returns series matching
+// the given selector within the lookback window.
+func hasSeriesInWindow(c apiClient, selector string) (bool, error) {
+	end := time.Now().Add(-*seriesWindowEnd)
+	series, err := getSeries(c, []string{selector}, end.Add(-*seriesWindow), end, 1)
+	if err != nil {
+		return false, fmt.Errorf("series request failed: %s", err)
+	}
+	return len(series) > 0, nil
+}
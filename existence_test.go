@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckSelectorSeriesBackend(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/query":
+			if r.URL.Query().Get("query") == "count(present)" {
+				fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1,"1"]}]}}`)
+				return
+			}
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+		case "/api/v1/series":
+			if r.URL.Query().Get("match[]") == "batch_job_last_success" {
+				fmt.Fprint(w, `{"status":"success","data":[{"__name__":"batch_job_last_success"}]}`)
+				return
+			}
+			fmt.Fprint(w, `{"status":"success","data":[]}`)
+		default:
+			t.Errorf("unexpected request: %v", r.URL)
+		}
+	}))
+	defer ts.Close()
+
+	backend := *existenceBackend
+	defer func() { *existenceBackend = backend }()
+	c := newAPIClient(ts.URL, nil)
+	e := map[string]map[string]selectorStatus{
+		"query": {
+			"present":                selectorOK,
+			"batch_job_last_success": selectorNoResults,
+			"missing":                selectorNoResults,
+		},
+		"series": {
+			"present":                selectorOK,
+			"batch_job_last_success": selectorNotCurrent,
+			"missing":                selectorNoResults,
+		},
+	}
+	for b, statuses := range e {
+		*existenceBackend = b
+		for selector, status := range statuses {
+			s, err := checkSelector(c, selector)
+			if err != nil {
+				t.Errorf("%s, %s: %v", b, selector, err)
+			}
+			if s != status {
+				t.Errorf("%s, %s: %v != %v", b, selector, s, status)
+			}
+		}
+	}
+}
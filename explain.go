@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	promql "github.com/prometheus/prometheus/promql/parser"
+	log "github.com/sirupsen/logrus"
+)
+
+// explainSelectors returns an explanation for each of the given selectors
+// which yield no results, keyed by selector. Selectors which cannot be parsed
+// or for which queries fail are not explained.
+func explainSelectors(c apiClient, selectors []string) map[string]string {
+	if len(selectors) == 0 {
+		return nil
+	}
+	var queryErr error
+	hasResults := func(matchers []*labels.Matcher) bool {
+		count, _, err := getResultCount(c, labelMatchersToString(matchers))
+		if err != nil && queryErr == nil {
+			queryErr = err
+		}
+		return count > 0
+	}
+	explanations := map[string]string{}
+	for _, selector := range selectors {
+		matchers, err := promql.ParseMetricSelector(selector)
+		if err != nil {
+			log.WithFields(log.Fields{"selector": selector, "err": err}).Warn("Metric selector parsing failed, not explaining selector")
+			continue
+		}
+		queryErr = nil
+		e := explainMatchers(matchers, hasResults)
+		if queryErr != nil {
+			log.WithFields(log.Fields{"selector": selector, "err": queryErr}).Warn("Failed to explain selector")
+			continue
+		}
+		if e != "" {
+			explanations[selector] = e
+		}
+	}
+	return explanations
+}
+
+// explainMatchers determines why the given matchers of a selector without
+// results do not match any series.
+// It first checks whether the metric exists at all. Afterwards, the other
+// matchers are added one by one until there are no more results. The
+// matchers which have been added until then are reduced to the smallest set
+// which still yields no results.
+// Selectors without metric name cannot be explained and yield an empty
+// string.
+func explainMatchers(matchers []*labels.Matcher, hasResults func([]*labels.Matcher) bool) string {
+	var name *labels.Matcher
+	var others []*labels.Matcher
+	for _, m := range matchers {
+		if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+			name = m
+			continue
+		}
+		others = append(others, m)
+	}
+	if name == nil {
+		return ""
+	}
+	if !hasResults([]*labels.Matcher{name}) {
+		return fmt.Sprintf("metric %s does not exist", name.Value)
+	}
+
+	var culprits []*labels.Matcher
+	for _, m := range others {
+		candidate := append(append([]*labels.Matcher{}, culprits...), m)
+		if hasResults(append([]*labels.Matcher{name}, candidate...)) {
+			culprits = candidate
+			continue
+		}
+		// Adding m made the selector empty. Try to remove each of the
+		// previously added matchers while keeping the result empty.
+		culprits = []*labels.Matcher{m}
+		for _, prev := range candidate[:len(candidate)-1] {
+			if hasResults(append([]*labels.Matcher{name}, culprits...)) {
+				culprits = append(culprits, prev)
+			}
+		}
+		if hasResults(append([]*labels.Matcher{name}, culprits...)) {
+			// Results have appeared in the meantime.
+			return ""
+		}
+		return describeCulprits(others, culprits)
+	}
+	return ""
+}
+
+// describeCulprits returns a description of the given set of matchers which
+// together do not match any series. The matchers are listed in the order in
+// which they appear in all.
+func describeCulprits(all, culprits []*labels.Matcher) string {
+	var s []string
+	for _, m := range all {
+		for _, c := range culprits {
+			if m == c {
+				s = append(s, m.String())
+			}
+		}
+	}
+	if len(s) == 1 {
+		return fmt.Sprintf("metric exists, but no series has %s", s[0])
+	}
+	return fmt.Sprintf("metric exists, but no series has %s together", strings.Join(s, " and "))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	promql "github.com/prometheus/prometheus/promql/parser"
+)
+
+func TestExplainMatchers(t *testing.T) {
+	series := []labels.Labels{
+		labels.FromStrings("__name__", "node_filesystem_free_bytes", "fstype", "ext4", "mountpoint", "/"),
+		labels.FromStrings("__name__", "node_filesystem_free_bytes", "fstype", "tmpfs", "mountpoint", "/run"),
+		labels.FromStrings("__name__", "node_filesystem_free_bytes", "fstype", "ext4", "mountpoint", "/data", "instance", "a"),
+	}
+	hasResults := func(matchers []*labels.Matcher) bool {
+	outer:
+		for _, s := range series {
+			for _, m := range matchers {
+				if !m.Matches(s.Get(m.Name)) {
+					continue outer
+				}
+			}
+			return true
+		}
+		return false
+	}
+	c := map[string]string{
+		`node_filesystem_free_bytes{fstype!~"tmpfs|rpc_pipefs",mountpoint="/data"}`:                      "",
+		`node_filesystem_avail_bytes{mountpoint="/"}`:                                                    "metric node_filesystem_avail_bytes does not exist",
+		`node_filesystem_free_bytes{fstype!~"tmpfs|rpc_pipefs",mountpoint="/srv"}`:                       `metric exists, but no series has mountpoint="/srv"`,
+		`node_filesystem_free_bytes{fstype="tmpfs",instance="",mountpoint="/data"}`:                      `metric exists, but no series has fstype="tmpfs" and mountpoint="/data" together`,
+		`{__name__="node_filesystem_free_bytes",fstype!~"tmpfs|rpc_pipefs",instance="b",mountpoint="/"}`: `metric exists, but no series has instance="b"`,
+		`{mountpoint="/srv"}`: "",
+	}
+	for s, e := range c {
+		m, err := promql.ParseMetricSelector(s)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		o := explainMatchers(m, hasResults)
+		if o != e {
+			t.Errorf("%s: %q != %q", s, o, e)
+		}
+	}
+}
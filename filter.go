@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/url"
+	"path/filepath"
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ruleTypeAPIValues maps the values of --filter.type to the rule types as
+// returned by the Prometheus API.
+var ruleTypeAPIValues = map[string]string{
+	"alert":  "alerting",
+	"record": "recording",
+}
+
+// ruleFilter decides which rules are checked.
+// Regexps are fully anchored.
+type ruleFilter struct {
+	ruleType      string
+	groups        []string
+	excludeGroups []string
+	files         []string
+	excludeFiles  []string
+	names         []string
+	excludeNames  []string
+}
+
+// newRuleFilter returns a ruleFilter based on the command line flags.
+func newRuleFilter() ruleFilter {
+	return ruleFilter{
+		ruleType:      *filterType,
+		groups:        *filterGroups,
+		excludeGroups: *filterExcludeGroups,
+		files:         *filterFiles,
+		excludeFiles:  *filterExcludeFiles,
+		names:         *filterNames,
+		excludeNames:  *filterExcludeNames,
+	}
+}
+
+// compileAnchoredRegexps compiles the given regexps, anchoring them at both
+// ends.
+func compileAnchoredRegexps(res []string) []*regexp.Regexp {
+	var compiled []*regexp.Regexp
+	for _, re := range res {
+		c, err := regexp.Compile("^(?:" + re + ")$")
+		if err != nil {
+			log.WithFields(log.Fields{"re": re, "err": err}).Fatal("Invalid rule filter regexp")
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled
+}
+
+// filterRuleGroups returns the given groups with all rules removed which do
+// not pass the filter. Groups without any remaining rules are removed as well.
+func (f ruleFilter) filterRuleGroups(groups []ruleGroup) []ruleGroup {
+	includeGroups, excludeGroups := compileAnchoredRegexps(f.groups), compileAnchoredRegexps(f.excludeGroups)
+	includeNames, excludeNames := compileAnchoredRegexps(f.names), compileAnchoredRegexps(f.excludeNames)
+	var filtered []ruleGroup
+	for _, g := range groups {
+		if !matchesAny(includeGroups, g.Name, true) || matchesAny(excludeGroups, g.Name, false) {
+			continue
+		}
+		if !matchesAnyGlob(f.files, g.File, true) || matchesAnyGlob(f.excludeFiles, g.File, false) {
+			continue
+		}
+		fg := g
+		fg.Rules = nil
+		for _, r := range g.Rules {
+			if f.ruleType != "" && r.Type != ruleTypeAPIValues[f.ruleType] {
+				continue
+			}
+			if !matchesAny(includeNames, r.Name, true) || matchesAny(excludeNames, r.Name, false) {
+				continue
+			}
+			fg.Rules = append(fg.Rules, r)
+		}
+		if len(fg.Rules) > 0 {
+			filtered = append(filtered, fg)
+		}
+	}
+	return filtered
+}
+
+// apiParams returns the query parameters for the Prometheus rules API which
+// let the server do the filtering as far as possible. Regexps and glob
+// patterns are only passed on if they are plain literals, as the API only
+// supports exact matches. Servers which do not support filtering ignore
+// these parameters and the filter is still applied on the client side.
+func (f ruleFilter) apiParams() url.Values {
+	params := url.Values{}
+	if f.ruleType != "" {
+		params.Set("type", f.ruleType)
+	}
+	if allLiteral(f.names, regexp.QuoteMeta) {
+		params["rule_name[]"] = f.names
+	}
+	if allLiteral(f.groups, regexp.QuoteMeta) {
+		params["rule_group[]"] = f.groups
+	}
+	if allLiteral(f.files, quoteGlobMeta) {
+		params["file[]"] = f.files
+	}
+	return params
+}
+
+// allLiteral returns true if there is at least one pattern and none of the
+// patterns contains meta characters, as determined by the given quote
+// function.
+func allLiteral(patterns []string, quote func(string) string) bool {
+	for _, p := range patterns {
+		if quote(p) != p {
+			return false
+		}
+	}
+	return len(patterns) > 0
+}
+
+// quoteGlobMeta escapes all glob meta characters in the given string.
+func quoteGlobMeta(s string) string {
+	return globMetaRe.ReplaceAllString(s, `\$0`)
+}
+
+var globMetaRe = regexp.MustCompile(`[*?\[\\]`)
+
+// matchesAny returns true if s matches any of the given regexps or, if
+// there are none, the given default.
+func matchesAny(res []*regexp.Regexp, s string, def bool) bool {
+	if len(res) == 0 {
+		return def
+	}
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAnyGlob returns true if s matches any of the given glob patterns
+// or, if there are none, the given default.
+func matchesAnyGlob(patterns []string, s string, def bool) bool {
+	if len(patterns) == 0 {
+		return def
+	}
+	for _, p := range patterns {
+		m, err := filepath.Match(p, s)
+		if err != nil {
+			log.WithFields(log.Fields{"pattern": p, "err": err}).Fatal("Invalid rule filter glob pattern")
+		}
+		if m {
+			return true
+		}
+	}
+	return false
+}
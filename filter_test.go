@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestFilterRuleGroups(t *testing.T) {
+	groups := []ruleGroup{
+		{
+			Name: "node",
+			File: "rules/node.yml",
+			Rules: []rule{
+				{Name: "InstanceDown", Type: "alerting"},
+				{Name: "instance:up:sum", Type: "recording"},
+				{Name: "InstanceFlapping", Type: "alerting"},
+			},
+		},
+		{
+			Name:  "node-extra",
+			File:  "rules/node.yml",
+			Rules: []rule{{Name: "InstanceDown", Type: "alerting"}},
+		},
+		{
+			Name:  "app",
+			File:  "other/app.yml",
+			Rules: []rule{{Name: "AppDown", Type: "alerting"}},
+		},
+	}
+	f := ruleFilter{
+		ruleType:      "alert",
+		groups:        []string{"node.*"},
+		files:         []string{"rules/*.yml"},
+		excludeNames:  []string{".*Flapping"},
+		excludeGroups: []string{"node-extra"},
+	}
+	e := []ruleGroup{
+		{
+			Name:  "node",
+			File:  "rules/node.yml",
+			Rules: []rule{{Name: "InstanceDown", Type: "alerting"}},
+		},
+	}
+	r := f.filterRuleGroups(groups)
+	if !reflect.DeepEqual(r, e) {
+		t.Errorf("%v != %v", r, e)
+	}
+}
+
+func TestRuleFilterAPIParams(t *testing.T) {
+	c := []struct {
+		f ruleFilter
+		e url.Values
+	}{
+		{
+			f: ruleFilter{},
+			e: url.Values{},
+		},
+		{
+			f: ruleFilter{ruleType: "record", names: []string{"job:up:sum"}, groups: []string{"node"}, files: []string{"/etc/prometheus/rules.yml"}},
+			e: url.Values{"type": {"record"}, "rule_name[]": {"job:up:sum"}, "rule_group[]": {"node"}, "file[]": {"/etc/prometheus/rules.yml"}},
+		},
+		{
+			f: ruleFilter{names: []string{"Instance.*"}, groups: []string{"node", "app"}, files: []string{"*.yml"}},
+			e: url.Values{"rule_group[]": {"node", "app"}},
+		},
+	}
+	for _, x := range c {
+		p := x.f.apiParams()
+		if !reflect.DeepEqual(p, x.e) {
+			t.Errorf("%v != %v", p, x.e)
+		}
+	}
+}
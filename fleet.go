@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// targetGroup is a single entry of a file_sd-style targets file.
+type targetGroup struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+// getServers returns the base URLs of all Prometheus servers which have been
+// given on the command line or via targets files.
+func getServers() []string {
+	servers := append([]string{}, *prometheusURLs...)
+	for _, path := range *prometheusTargetsFiles {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.WithFields(log.Fields{"path": path, "err": err}).Fatal("Targets file reading failed")
+		}
+		s, err := parseTargetsFile(b)
+		if err != nil {
+			log.WithFields(log.Fields{"path": path, "err": err}).Fatal("Targets file parsing failed")
+		}
+		servers = append(servers, s...)
+	}
+	return servers
+}
+
+// parseTargetsFile parses the given file_sd-style JSON or YAML content and
+// returns the base URLs of all targets.
+// Targets without a scheme use the __scheme__ label or http.
+func parseTargetsFile(b []byte) ([]string, error) {
+	var groups []targetGroup
+	err := yaml.Unmarshal(b, &groups)
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	for _, g := range groups {
+		scheme := g.Labels["__scheme__"]
+		if scheme == "" {
+			scheme = "http"
+		}
+		for _, t := range g.Targets {
+			if !strings.Contains(t, "://") {
+				t = fmt.Sprintf("%s://%s", scheme, t)
+			}
+			servers = append(servers, t)
+		}
+	}
+	return servers, nil
+}
+
+// checkServers checks the rule groups returned by getGroups for each of the
+// given servers against that same server.
+// The results are returned in server order. If multiple servers are checked,
+// each result is labelled with its server.
+// Returns true as second value if any server could not be checked.
+func checkServers(servers []string, getGroups func(server string) ([]ruleGroup, error)) ([]resultItem, bool) {
+	results := make([][]resultItem, len(servers))
+	failed := forEachServer(servers, func(i int, server string) error {
+		groups, err := getGroups(server)
+		if err != nil {
+			return err
+		}
+		results[i], err = checkRules(server, groups)
+		if err != nil {
+			return err
+		}
+		if len(servers) > 1 {
+			for j := range results[i] {
+				results[i][j].Server = server
+			}
+		}
+		return nil
+	})
+
+	var all []resultItem
+	for i := range servers {
+		all = append(all, results[i]...)
+	}
+	return all, failed
+}
+
+// forEachServer calls f for each of the given servers, passing the server's
+// index. Up to --prometheus.concurrency calls run concurrently.
+// Errors are logged. Returns true if any call failed.
+func forEachServer(servers []string, f func(i int, server string) error) bool {
+	failed := make([]bool, len(servers))
+	concurrency := make(chan struct{}, *fleetConcurrency)
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			concurrency <- struct{}{}
+			defer func() { <-concurrency }()
+			log.WithFields(log.Fields{"prometheus.url": server}).Debug("Querying")
+			err := f(i, server)
+			if err != nil {
+				log.WithFields(log.Fields{"prometheus.url": server, "err": err}).Error("Server check failed")
+				failed[i] = true
+			}
+		}(i, server)
+	}
+	wg.Wait()
+
+	for _, f := range failed {
+		if f {
+			return true
+		}
+	}
+	return false
+}
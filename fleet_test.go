@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseTargetsFile(t *testing.T) {
+	f := `[
+  {"targets": ["prom-a:9090", "prom-b:9090"], "labels": {"env": "prod"}},
+  {"targets": ["prom-c:443"], "labels": {"__scheme__": "https"}},
+  {"targets": ["http://prom-d:9090/prometheus"]}
+]`
+	s, err := parseTargetsFile([]byte(f))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	e := []string{
+		"http://prom-a:9090",
+		"http://prom-b:9090",
+		"https://prom-c:443",
+		"http://prom-d:9090/prometheus",
+	}
+	if !reflect.DeepEqual(s, e) {
+		t.Errorf("%v != %v", s, e)
+	}
+}
+
+func TestCheckServersFailure(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+	}))
+	defer healthy.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprint(w, `{"status":"error","error":"query limit exceeded"}`)
+	}))
+	defer broken.Close()
+
+	concurrency := *fleetConcurrency
+	defer func() { *fleetConcurrency = concurrency }()
+	*fleetConcurrency = 2
+	groups := []ruleGroup{{Name: "g", Rules: []rule{{Name: "r", Query: "missing"}}}}
+	r, failed := checkServers([]string{broken.URL, healthy.URL}, func(server string) ([]ruleGroup, error) {
+		return groups, nil
+	})
+	if !failed {
+		t.Errorf("failure of %s not reported", broken.URL)
+	}
+	if len(r) != 1 || r[0].Server != healthy.URL || !reflect.DeepEqual(r[0].NoResultSelectors, []string{"missing"}) {
+		t.Errorf("unexpected results: %+v", r)
+	}
+}
+
+func TestCheckServersParseError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+	}))
+	defer ts.Close()
+
+	concurrency := *fleetConcurrency
+	defer func() { *fleetConcurrency = concurrency }()
+	*fleetConcurrency = 1
+	groups := []ruleGroup{{Name: "g", Rules: []rule{{Name: "a", Query: "sum(missing"}, {Name: "b", Query: "missing"}}}}
+	r, failed := checkServers([]string{ts.URL}, func(server string) ([]ruleGroup, error) {
+		return groups, nil
+	})
+	if failed {
+		t.Errorf("parse error reported as server failure")
+	}
+	if len(r) != 2 || r[0].Name != "a" || r[0].ParseError == "" || r[1].Name != "b" || !reflect.DeepEqual(r[1].NoResultSelectors, []string{"missing"}) {
+		t.Errorf("unexpected results: %+v", r)
+	}
+	if !hasFindings(r[:1]) {
+		t.Errorf("parse error not reported as finding")
+	}
+}
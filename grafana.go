@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+
+	promql "github.com/prometheus/prometheus/promql/parser"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// grafanaAlertingExport is the structure of a Grafana alert rule provisioning
+// file or export (JSON or YAML).
+type grafanaAlertingExport struct {
+	APIVersion int `yaml:"apiVersion"`
+	Groups     []struct {
+		Name   string `yaml:"name"`
+		Folder string `yaml:"folder"`
+		Rules  []struct {
+			Title string `yaml:"title"`
+			Data  []struct {
+				RefID         string `yaml:"refId"`
+				DatasourceUID string `yaml:"datasourceUid"`
+				Model         struct {
+					Expr       string    `yaml:"expr"`
+					Datasource yaml.Node `yaml:"datasource"`
+				} `yaml:"model"`
+			} `yaml:"data"`
+		} `yaml:"rules"`
+	} `yaml:"groups"`
+}
+
+// grafanaExpressionDatasourceUIDs are the pseudo datasource UIDs which
+// Grafana uses for server-side expressions (math, reduce, threshold, ...).
+var grafanaExpressionDatasourceUIDs = map[string]bool{
+	"__expr__": true,
+	"-100":     true,
+}
+
+// getRuleGroupsFromGrafanaAlerts reads all Grafana alert rule provisioning
+// files matching the given glob patterns.
+func getRuleGroupsFromGrafanaAlerts(patterns []string) []ruleGroup {
+	var groups []ruleGroup
+	for _, path := range globFiles(patterns) {
+		log.WithFields(log.Fields{"path": path}).Debug("Reading Grafana alerting file")
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.WithFields(log.Fields{"path": path, "err": err}).Fatal("Grafana alerting file reading failed")
+		}
+		g, err := parseGrafanaAlerts(b, *grafanaDatasourceUIDs, *grafanaAssumePrometheus)
+		if err != nil {
+			log.WithFields(log.Fields{"path": path, "err": err}).Fatal("Grafana alerting file parsing failed")
+		}
+		groups = append(groups, g...)
+	}
+	return groups
+}
+
+// parseGrafanaAlerts parses the given Grafana alert rule provisioning file
+// content (JSON is handled as YAML) and returns a rule for each PromQL query.
+// Expression nodes, queries for datasources of other types and unparsable
+// queries are skipped. If datasourceUIDs is non-empty, only queries for these
+// datasources are considered and they are known to be Prometheus
+// datasources. Queries for datasources whose type cannot be determined
+// otherwise are skipped unless assumePrometheus is set.
+// The resulting groups are labelled with their folder.
+func parseGrafanaAlerts(b []byte, datasourceUIDs []string, assumePrometheus bool) ([]ruleGroup, error) {
+	var e grafanaAlertingExport
+	err := yaml.Unmarshal(b, &e)
+	if err != nil {
+		return nil, err
+	}
+	datasources := map[string]bool{}
+	for _, uid := range datasourceUIDs {
+		datasources[uid] = true
+	}
+	var groups []ruleGroup
+	for _, g := range e.Groups {
+		rg := ruleGroup{Name: g.Name, File: g.Folder}
+		for _, r := range g.Rules {
+			for _, d := range r.Data {
+				if grafanaExpressionDatasourceUIDs[d.DatasourceUID] || d.Model.Expr == "" {
+					continue
+				}
+				if len(datasources) > 0 && !datasources[d.DatasourceUID] {
+					log.WithFields(log.Fields{"title": r.Title, "refId": d.RefID, "datasourceUid": d.DatasourceUID}).Debug("Skipping query for other datasource")
+					continue
+				}
+				switch typ := getDatasourceNodeType(d.Model.Datasource); {
+				case typ == "" && !datasources[d.DatasourceUID] && !assumePrometheus:
+					log.WithFields(log.Fields{"title": r.Title, "refId": d.RefID, "datasourceUid": d.DatasourceUID}).Debug("Skipping query for datasource of unknown type")
+					continue
+				case typ != "" && typ != "prometheus":
+					log.WithFields(log.Fields{"title": r.Title, "refId": d.RefID, "datasourceUid": d.DatasourceUID, "type": typ}).Debug("Skipping query for non-Prometheus datasource")
+					continue
+				}
+				if _, err := promql.ParseExpr(d.Model.Expr); err != nil {
+					log.WithFields(log.Fields{"title": r.Title, "refId": d.RefID, "query": d.Model.Expr, "err": err}).Warn("Skipping unparsable Grafana alert query")
+					continue
+				}
+				rg.Rules = append(rg.Rules, rule{Name: r.Title, Query: d.Model.Expr, Type: "alerting"})
+			}
+		}
+		groups = append(groups, rg)
+	}
+	return groups, nil
+}
+
+// getDatasourceNodeType returns the type of the given datasource reference
+// within a YAML document or an empty string if it is unknown, e.g. because
+// the datasource is referenced by name only.
+func getDatasourceNodeType(n yaml.Node) string {
+	var ds struct {
+		Type string `yaml:"type"`
+	}
+	if n.Kind != yaml.MappingNode || n.Decode(&ds) != nil {
+		return ""
+	}
+	return ds.Type
+}
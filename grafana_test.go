@@ -0,0 +1,134 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGrafanaAlerts(t *testing.T) {
+	f := `apiVersion: 1
+groups:
+  - orgId: 1
+    name: nodes
+    folder: Infrastructure
+    interval: 1m
+    rules:
+      - uid: abc
+        title: Instance down
+        condition: C
+        data:
+          - refId: A
+            datasourceUid: prometheus
+            model:
+              expr: up{job="node"}
+              refId: A
+          - refId: B
+            datasourceUid: loki
+            model:
+              expr: count_over_time({job="node"}[5m])
+              refId: B
+          - refId: C
+            datasourceUid: __expr__
+            model:
+              type: math
+              expression: $A == 0
+`
+	g, err := parseGrafanaAlerts([]byte(f), []string{"prometheus"}, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	e := []ruleGroup{
+		{
+			Name:  "nodes",
+			File:  "Infrastructure",
+			Rules: []rule{{Name: "Instance down", Query: "up{job=\"node\"}", Type: "alerting"}},
+		},
+	}
+	if !reflect.DeepEqual(g, e) {
+		t.Errorf("%v != %v", g, e)
+	}
+}
+
+func TestParseGrafanaAlertsDatasourceTypes(t *testing.T) {
+	f := `apiVersion: 1
+groups:
+  - name: logs
+    folder: Apps
+    rules:
+      - title: Errors
+        data:
+          - refId: A
+            datasourceUid: loki
+            model:
+              datasource:
+                type: loki
+                uid: loki
+              expr: sum(rate({app="x"}[5m]))
+          - refId: B
+            datasourceUid: other-loki
+            model:
+              expr: sum(count_over_time({app="x"} |= "error" [5m]))
+          - refId: C
+            datasourceUid: prom
+            model:
+              datasource:
+                type: prometheus
+                uid: prom
+              expr: up{job="x"}
+`
+	g, err := parseGrafanaAlerts([]byte(f), nil, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	e := []ruleGroup{
+		{
+			Name:  "logs",
+			File:  "Apps",
+			Rules: []rule{{Name: "Errors", Query: "up{job=\"x\"}", Type: "alerting"}},
+		},
+	}
+	if !reflect.DeepEqual(g, e) {
+		t.Errorf("%v != %v", g, e)
+	}
+}
+
+func TestParseGrafanaAlertsUnknownDatasourceType(t *testing.T) {
+	f := `apiVersion: 1
+groups:
+  - name: logs
+    folder: Apps
+    rules:
+      - title: Errors
+        data:
+          - refId: A
+            datasourceUid: loki
+            model:
+              expr: sum(rate({app="x"}[5m]))
+          - refId: B
+            datasourceUid: prom
+            model:
+              expr: up{job="x"}
+`
+	c := map[string]struct {
+		datasourceUIDs   []string
+		assumePrometheus bool
+		queries          []string
+	}{
+		"unknown":           {nil, false, nil},
+		"listed":            {[]string{"prom"}, false, []string{`up{job="x"}`}},
+		"assume prometheus": {nil, true, []string{`sum(rate({app="x"}[5m]))`, `up{job="x"}`}},
+	}
+	for name, tc := range c {
+		g, err := parseGrafanaAlerts([]byte(f), tc.datasourceUIDs, tc.assumePrometheus)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		var queries []string
+		for _, r := range g[0].Rules {
+			queries = append(queries, r.Query)
+		}
+		if !reflect.DeepEqual(queries, tc.queries) {
+			t.Errorf("%s: %v != %v", name, queries, tc.queries)
+		}
+	}
+}
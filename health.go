@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// getHealthProblems returns descriptions of all problems with the given
+// rule's evaluation, based on the evaluation state reported by the API.
+// Rules without evaluation state (e.g. from rule files) have no problems.
+func getHealthProblems(g ruleGroup, r rule, now time.Time) []string {
+	var problems []string
+	switch r.Health {
+	case "err":
+		problems = append(problems, fmt.Sprintf("health is err: %s", r.LastError))
+	case "unknown":
+		problems = append(problems, "health is unknown (rule has not been evaluated yet)")
+	}
+	if *healthStaleIntervals > 0 && g.Interval > 0 && !r.LastEvaluation.IsZero() {
+		interval := time.Duration(g.Interval * float64(time.Second))
+		since := now.Sub(r.LastEvaluation)
+		if since > time.Duration(*healthStaleIntervals*float64(interval)) {
+			problems = append(problems, fmt.Sprintf("not evaluated for %s (group interval: %s)", since.Round(time.Second), interval))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetHealthProblems(t *testing.T) {
+	staleIntervals := *healthStaleIntervals
+	defer func() { *healthStaleIntervals = staleIntervals }()
+	*healthStaleIntervals = 3
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	g := ruleGroup{Interval: 60}
+	c := []struct {
+		r rule
+		e []string
+	}{
+		{
+			r: rule{Health: "ok", LastEvaluation: now.Add(-time.Minute)},
+			e: nil,
+		},
+		{
+			r: rule{},
+			e: nil,
+		},
+		{
+			r: rule{Health: "err", LastError: "vector contains metrics with the same labelset after applying alert labels", LastEvaluation: now.Add(-time.Minute)},
+			e: []string{"health is err: vector contains metrics with the same labelset after applying alert labels"},
+		},
+		{
+			r: rule{Health: "unknown"},
+			e: []string{"health is unknown (rule has not been evaluated yet)"},
+		},
+		{
+			r: rule{Health: "ok", LastEvaluation: now.Add(-5 * time.Minute)},
+			e: []string{"not evaluated for 5m0s (group interval: 1m0s)"},
+		},
+	}
+	for _, x := range c {
+		p := getHealthProblems(g, x.r, now)
+		if !reflect.DeepEqual(p, x.e) {
+			t.Errorf("%v: %v != %v", x.r, p, x.e)
+		}
+	}
+}
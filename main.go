@@ -3,8 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"net/url"
 	"os"
 	"regexp"
 	"strconv"
@@ -19,108 +18,447 @@ import (
 
 var (
 	verbose                 = kingpin.Flag("verbose", "Verbose mode.").Short('v').Bool()
-	url                     = kingpin.Flag("prometheus.url", "prometheus base URL").Required().String()
-	waitTime                = kingpin.Flag("wait.seconds", "seconds to wait between count requests").Default("0.01").Float()
-	expandRegexps           = kingpin.Flag("expand.regexps", "whether to query a|b|c-style patterns individually").Default("true").Bool()
+	prometheusURLs          = kingpin.Flag("prometheus.url", "prometheus base URL; can be given multiple times to check multiple servers").Strings()
+	prometheusTargetsFiles  = kingpin.Flag("prometheus.targets-file", "read prometheus servers to check from the given file_sd-style JSON/YAML file; can be given multiple times").Strings()
+	fleetConcurrency        = kingpin.Flag("prometheus.concurrency", "number of prometheus servers to check concurrently").Default("4").Int()
+	waitTime                = kingpin.Flag("wait.seconds", "minimum average seconds between requests to a server; the request rate is reduced automatically if the server is overloaded").Default("0.01").Float()
+	queryConcurrency        = kingpin.Flag("query.concurrency", "maximum number of concurrent queries per rule group").Default("4").Int()
+	expandRegexps           = kingpin.Flag("expand.regexps", "whether to query the values of finite regexps such as a|b|c or (prod|stage)-db individually").Default("true").Bool()
+	expandMax               = kingpin.Flag("expand.max", "maximum number of values to expand a single regexp into; larger regexps are checked as a whole").Default("256").Int()
 	outputFormat            = kingpin.Flag("output.format", "how to format results").Default("human").Enum("human", "csv", "json")
 	ignoredSelectorsRegexps = kingpin.Flag("ignored-selectors.regexp", "ignore all findings which match this regular expression; can be given multiple times").Strings()
+	rulesFiles              = kingpin.Flag("rules.file", "read rules from the given rule files (glob patterns allowed) instead of the Prometheus API; can be given multiple times").Strings()
+	prometheusRuleFiles     = kingpin.Flag("prometheusrule.file", "read rules from PrometheusRule objects in the given Kubernetes manifest files (glob patterns allowed) instead of the Prometheus API; can be given multiple times").Strings()
+	mimirTenants            = kingpin.Flag("mimir.tenant", "retrieve rules from a Mimir/Cortex ruler for the given tenant and query with the tenant's X-Scope-OrgID; can be given multiple times").Strings()
+	mimirRulesAPI           = kingpin.Flag("mimir.rules-api", "which Mimir/Cortex ruler API to retrieve rules from; the config API also provides source_tenants").Default("prometheus").Enum("prometheus", "config")
+	grafanaAlertFiles       = kingpin.Flag("grafana.alerts-file", "read Grafana-managed alert rules from the given provisioning files or exports (glob patterns allowed) instead of the Prometheus API; can be given multiple times").Strings()
+	grafanaDatasourceUIDs   = kingpin.Flag("grafana.datasource-uid", "only check Grafana queries for the datasource with the given UID; can be given multiple times").Strings()
+	grafanaAssumePrometheus = kingpin.Flag("grafana.assume-prometheus", "check Grafana queries whose datasource type cannot be determined as if they were for a Prometheus datasource").Bool()
+	dashboardFiles          = kingpin.Flag("dashboard.file", "check the PromQL queries of the given Grafana dashboard JSON files (glob patterns allowed) instead of rules from the Prometheus API; can be given multiple times").Strings()
+	dashboardVariables      = kingpin.Flag("dashboard.variable", "value to use for the given dashboard template variable (name=value); can be given multiple times").StringMap()
+	dashboardFetchVariables = kingpin.Flag("dashboard.fetch-variables", "resolve label_values() template variables using the Prometheus API").Bool()
+	thanosRulerURLs         = kingpin.Flag("thanos.ruler-url", "retrieve rules from the given Thanos Ruler base URL instead of --prometheus.url, which is then only used for queries (e.g. Thanos Query); can be given multiple times").Strings()
+	thanosDedup             = kingpin.Flag("thanos.dedup", "set Thanos' dedup parameter for queries").Enum("true", "false")
+	thanosPartialResponse   = kingpin.Flag("thanos.partial-response", "set Thanos' partial_response parameter for queries").Enum("true", "false")
+	thanosMaxSourceRes      = kingpin.Flag("thanos.max-source-resolution", "set Thanos' max_source_resolution parameter for queries (e.g. 5m, 1h or auto)").String()
+	existenceBackend        = kingpin.Flag("existence.backend", "how to check selectors for existence: query (instant count() query) or series (additionally check the series API for a lookback window)").Default("query").Enum("query", "series")
+	seriesWindow            = kingpin.Flag("series.window", "lookback window for the series existence backend").Default("24h").Duration()
+	seriesWindowEnd         = kingpin.Flag("series.window-end", "end of the lookback window for the series existence backend, relative to now").Default("0s").Duration()
+	seriesIndexEnabled      = kingpin.Flag("series-index", "check selectors locally against the current series of their metric, which are retrieved once per metric; greatly reduces the number of queries").Bool()
+	seriesIndexLimit        = kingpin.Flag("series-index.limit", "maximum number of series per metric in the series index; selectors of metrics with more series are checked using queries").Default("10000").Int()
+	batchSize               = kingpin.Flag("batch.size", "number of selectors to check using a single combined query; 1 disables batching").Default("1").Int()
+	batchMaxLength          = kingpin.Flag("batch.max-length", "maximum length of a combined query in characters").Default("16384").Int()
+	cacheFile               = kingpin.Flag("cache.file", "persist selector check results in the given file so that subsequent runs can reuse them").String()
+	cacheTTL                = kingpin.Flag("cache.ttl", "maximum age of results from --cache.file").Default("5m").Duration()
+	checkExclusions         = kingpin.Flag("exclusions.check", "report values excluded by != and !~ matchers which no series of the metric has (dead code); causes additional queries").Bool()
+	resolveNames            = kingpin.Flag("metric-names.resolve", "report the metric names matched by metric name regexps and selectors without metric name").Default("true").Bool()
+	namelessMaxSeries       = kingpin.Flag("nameless.max-series", "warn about selectors without metric name which match more than this many series; causes an additional count() query per selector; 0 disables this check").Default("0").Int()
+	explain                 = kingpin.Flag("explain", "explain why selectors yield no results by checking their label matchers individually; causes additional queries").Bool()
+	prefetchMetricNames     = kingpin.Flag("prefetch.metric-names", "retrieve all metric names once and report selectors of missing metrics without querying them").Default("true").Bool()
+	suggest                 = kingpin.Flag("suggest", "suggest similar existing metrics, label names and values for selectors without results; causes additional queries").Bool()
+	suggestMax              = kingpin.Flag("suggest.max", "maximum number of suggestions per metric or label matcher").Default("3").Int()
+	healthStaleIntervals    = kingpin.Flag("health.stale-intervals", "report rules which have not been evaluated for more than this many group intervals; 0 disables this check").Default("3").Float()
+	filterType              = kingpin.Flag("filter.type", "only check rules of the given type").Enum("alert", "record")
+	filterGroups            = kingpin.Flag("filter.group", "only check rule groups whose name matches this (fully anchored) regular expression; can be given multiple times").Strings()
+	filterExcludeGroups     = kingpin.Flag("filter.exclude-group", "do not check rule groups whose name matches this (fully anchored) regular expression; can be given multiple times").Strings()
+	filterFiles             = kingpin.Flag("filter.file", "only check rule groups whose file matches this glob pattern; can be given multiple times").Strings()
+	filterExcludeFiles      = kingpin.Flag("filter.exclude-file", "do not check rule groups whose file matches this glob pattern; can be given multiple times").Strings()
+	filterNames             = kingpin.Flag("filter.name", "only check rules whose name matches this (fully anchored) regular expression; can be given multiple times").Strings()
+	filterExcludeNames      = kingpin.Flag("filter.exclude-name", "do not check rules whose name matches this (fully anchored) regular expression; can be given multiple times").Strings()
+
+	checkRulesCmd = kingpin.Command("check-rules", "check the selectors of all rules (default)").Default()
+	checkExprCmd  = kingpin.Command("check-expr", "check the selectors of the given PromQL expressions")
+	checkExprArgs = checkExprCmd.Arg("expr", "PromQL expressions to check; read from stdin (one per line) if neither expressions nor --file are given").Strings()
+	checkExprFile = checkExprCmd.Flag("file", "read PromQL expressions from the given file (one per line, - for stdin)").String()
+
+	evaluationReportCmd       = kingpin.Command("evaluation-report", "rank rule groups and rules by evaluation time instead of checking selectors")
+	evaluationReportThreshold = evaluationReportCmd.Flag("threshold", "report groups as slow if their evaluation time exceeds this fraction of their interval").Default("0.8").Float()
 )
 
 func main() {
-	kingpin.Parse()
+	cmd := kingpin.Parse()
 	if *verbose {
 		log.SetLevel(log.DebugLevel)
 	} else {
 		log.SetLevel(log.InfoLevel)
 	}
-	log.WithFields(log.Fields{"prometheus.url": *url}).Debug("Querying")
+	servers := getServers()
+	if len(servers) == 0 {
+		kingpin.Fatalf("required flag --prometheus.url or --prometheus.targets-file not provided")
+	}
 
-	found := checkRules()
-	if found {
+	getGroups := func(server string) ([]ruleGroup, error) {
+		groups, err := getRuleGroups(server)
+		return newRuleFilter().filterRuleGroups(groups), err
+	}
+	if cmd == evaluationReportCmd.FullCommand() {
+		report, failed := getEvaluationReport(servers, getGroups)
+		printEvaluationReport(report)
+		if failed || hasSlowGroups(report) {
+			os.Exit(1)
+		}
+		return
+	}
+	if cmd == checkExprCmd.FullCommand() {
+		// Expressions may be read from stdin and therefore only once.
+		groups := withTenants(getRuleGroupsFromExpressions(*checkExprArgs, *checkExprFile), *mimirTenants)
+		getGroups = func(string) ([]ruleGroup, error) { return groups, nil }
+	}
+	if *cacheFile != "" {
+		err := resultCache.load(*cacheFile)
+		if err != nil {
+			log.WithFields(log.Fields{"path": *cacheFile, "err": err}).Warn("Failed to load cache")
+		}
+	}
+	results, failed := checkServers(servers, getGroups)
+	printResults(results)
+	stats.log()
+	if *cacheFile != "" {
+		err := resultCache.save(*cacheFile)
+		if err != nil {
+			log.WithFields(log.Fields{"path": *cacheFile, "err": err}).Warn("Failed to save cache")
+		}
+	}
+	if failed || hasFindings(results) {
 		os.Exit(1)
 	}
 }
 
-// checkRules is the main entry point, connects to the Prometheus API, retrieves all defined rules and analyzes the PromQL expressions for dead metric references.
-// Returns true if problematic rules have been found.
-func checkRules() bool {
-	resp, err := http.Get(fmt.Sprintf("%s/api/v1/rules", *url))
-	log.WithFields(log.Fields{"resp": resp, "err": err}).Debug("rule query result")
-	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Fatal("Rule request failed")
+// getRuleGroups retrieves the rule groups from all configured sources or
+// from the given Prometheus server's API if no other source has been
+// configured.
+func getRuleGroups(server string) ([]ruleGroup, error) {
+	var groups []ruleGroup
+	if len(*rulesFiles) > 0 {
+		groups = append(groups, getRuleGroupsFromFiles(*rulesFiles)...)
+	}
+	if len(*prometheusRuleFiles) > 0 {
+		groups = append(groups, getRuleGroupsFromPrometheusRules(*prometheusRuleFiles)...)
+	}
+	if len(*grafanaAlertFiles) > 0 {
+		groups = append(groups, getRuleGroupsFromGrafanaAlerts(*grafanaAlertFiles)...)
+	}
+	if len(*dashboardFiles) > 0 {
+		groups = append(groups, getRuleGroupsFromDashboards(*dashboardFiles, server)...)
+	}
+	if len(*thanosRulerURLs) > 0 {
+		g, err := getRuleGroupsFromThanosRulers(*thanosRulerURLs)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g...)
+	}
+	if len(*rulesFiles) == 0 && len(*prometheusRuleFiles) == 0 && len(*grafanaAlertFiles) == 0 && len(*dashboardFiles) == 0 && len(*thanosRulerURLs) == 0 {
+		if len(*mimirTenants) > 0 {
+			return getRuleGroupsFromMimir(server, *mimirTenants)
+		}
+		return getRuleGroupsFromAPI(newAPIClient(server, nil))
 	}
+	return withTenants(groups, *mimirTenants), nil
+}
+
+// ruleGroup is a named group of rules, either as returned by the Prometheus
+// API or as read from a rule file.
+// Tenant and SourceTenants are only set for Mimir/Cortex rule groups or if
+// --mimir.tenant is given.
+// Interval and the evaluation state (both in seconds) are only known for
+// groups retrieved from the API.
+type ruleGroup struct {
+	Name           string
+	File           string
+	Tenant         string
+	SourceTenants  []string
+	Interval       float64
+	EvaluationTime float64
+	LastEvaluation time.Time
+	Rules          []rule
+}
 
-	b, err := ioutil.ReadAll(resp.Body)
+// queryTenants returns the tenants whose data the group's rules are
+// evaluated against.
+func (g ruleGroup) queryTenants() []string {
+	if len(g.SourceTenants) > 0 {
+		return g.SourceTenants
+	}
+	if g.Tenant != "" {
+		return []string{g.Tenant}
+	}
+	return nil
+}
+
+// rule is a single alerting or recording rule.
+// Line is only known for rules which have been read from a rule file.
+// The evaluation state (Health etc.) is only known for rules which have been
+// retrieved from the API.
+type rule struct {
+	Name           string
+	Query          string
+	Type           string
+	Line           int
+	Health         string
+	LastError      string
+	LastEvaluation time.Time
+	EvaluationTime float64
+}
+
+// getRuleGroupsFromAPI connects to the Prometheus API and retrieves all defined rules.
+// The rule filter is passed on to the API as far as possible.
+func getRuleGroupsFromAPI(c apiClient) ([]ruleGroup, error) {
+	b, err := c.get("/api/v1/rules", newRuleFilter().apiParams())
 	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Fatal("Body reading failed")
+		return nil, fmt.Errorf("rule request failed: %s", err)
 	}
 
 	j := struct {
 		Status string
 		Data   struct {
-			Groups []struct {
-				Name  string
-				File  string
-				Rules []struct {
-					Name  string
-					Query string
-				}
-			}
+			Groups []ruleGroup
 		}
 	}{}
 	err = json.Unmarshal(b, &j)
 	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Fatal("json parsing failed")
+		return nil, fmt.Errorf("json parsing failed: %s", err)
 	}
 
 	if j.Status != "success" {
-		log.WithFields(log.Fields{"status": j.Status}).Fatal("Unexpected status in rule request")
+		return nil, fmt.Errorf("unexpected status in rule request: %s", j.Status)
 	}
+	return j.Data.Groups, nil
+}
 
-	type resultItem struct {
-		File              string
-		Group             string
-		Name              string
-		Query             string
-		NoResultSelectors []string
-	}
+// resultItem describes the findings for a single rule.
+// Server is only set when checking multiple servers.
+type resultItem struct {
+	Server                 string `json:",omitempty"`
+	Tenant                 string `json:",omitempty"`
+	File                   string
+	Line                   int `json:",omitempty"`
+	Group                  string
+	Name                   string
+	Query                  string
+	ParseError             string `json:",omitempty"`
+	NoResultSelectors      []string
+	EmptyFilterSelectors   []string            `json:",omitempty"`
+	EmptyGuardSelectors    []string            `json:",omitempty"`
+	EmptyFallbackSelectors []string            `json:",omitempty"`
+	InconclusiveSelectors  []string            `json:",omitempty"`
+	NotCurrentSelectors    []string            `json:",omitempty"`
+	UnexpandedSelectors    []string            `json:",omitempty"`
+	NeverExistedSelectors  []string            `json:",omitempty"`
+	DeadExclusions         map[string][]string `json:",omitempty"`
+	MetricNames            map[string][]string `json:",omitempty"`
+	Warnings               map[string][]string `json:",omitempty"`
+	HealthProblems         []string            `json:",omitempty"`
+	Explanations           map[string]string   `json:",omitempty"`
+	Suggestions            map[string][]string `json:",omitempty"`
+}
+
+// checkRules is the main entry point, analyzes the PromQL expressions of the given rule groups for dead metric references using the given Prometheus server.
+// Returns all rules with findings. Rules whose expression cannot be parsed
+// are reported with their parse error.
+// Returns an error if the server could not be queried.
+func checkRules(server string, groups []ruleGroup) ([]resultItem, error) {
 	var results []resultItem
-	for _, g := range j.Data.Groups {
-		for _, r := range g.Rules {
-			log.WithFields(log.Fields{"group": g.Name, "file": g.File, "name": r.Name, "query": r.Query}).Debug("Checking rule")
-			selectors := getNoResultSelectors(r.Query)
-			if selectors != nil {
-				ri := resultItem{Group: g.Name, File: g.File, Name: r.Name, Query: r.Query}
-				for _, selector := range selectors {
-					if isSelectorIgnored(selector) {
-						continue
-					}
-					ri.NoResultSelectors = append(ri.NoResultSelectors, selector)
+	now := time.Now()
+	for _, g := range groups {
+		c := newAPIClient(server, g.queryTenants())
+		c.params = thanosQueryParams()
+		var queries []string
+		parseErrors := make([]string, len(g.Rules))
+		for i, r := range g.Rules {
+			log.WithFields(log.Fields{"server": server, "tenant": g.Tenant, "group": g.Name, "file": g.File, "line": r.Line, "name": r.Name, "query": r.Query}).Debug("Checking rule")
+			if _, err := getSelectorRefs(r.Query); err != nil {
+				parseErrors[i] = err.Error()
+				continue
+			}
+			queries = append(queries, r.Query)
+		}
+		groupSelectors, err := getNoResultSelectorsForQueries(c, queries)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %s", g.Name, err)
+		}
+		for i, r := range g.Rules {
+			ri := resultItem{Tenant: g.Tenant, Group: g.Name, File: g.File, Line: r.Line, Name: r.Name, Query: r.Query}
+			if parseErrors[i] != "" {
+				ri.ParseError = parseErrors[i]
+				results = append(results, ri)
+				continue
+			}
+			selectors := groupSelectors[0]
+			groupSelectors = groupSelectors[1:]
+			ri.NoResultSelectors = filterIgnoredSelectors(selectors.noResults)
+			ri.EmptyFilterSelectors = filterIgnoredSelectors(selectors.emptyFilters)
+			ri.EmptyGuardSelectors = filterIgnoredSelectors(selectors.emptyGuards)
+			ri.EmptyFallbackSelectors = filterIgnoredSelectors(selectors.emptyFallbacks)
+			ri.InconclusiveSelectors = filterIgnoredSelectors(selectors.inconclusive)
+			ri.NotCurrentSelectors = filterIgnoredSelectors(selectors.notCurrent)
+			ri.UnexpandedSelectors = filterIgnoredSelectors(selectors.unexpanded)
+			ri.NeverExistedSelectors = filterIgnoredSelectors(selectors.neverExisted)
+			ri.HealthProblems = getHealthProblems(g, r, now)
+			if *checkExclusions {
+				ri.DeadExclusions, err = findDeadExclusions(c, r.Query)
+				if err != nil {
+					ri.ParseError = err.Error()
 				}
-				if len(ri.NoResultSelectors) < 1 {
-					continue
+			}
+			if *resolveNames {
+				ri.MetricNames, ri.Warnings, err = resolveMetricNames(c, r.Query)
+				if err != nil {
+					ri.ParseError = err.Error()
 				}
-				results = append(results, ri)
 			}
+			explained := append(append(append([]string{}, ri.NoResultSelectors...), ri.EmptyFilterSelectors...), ri.EmptyGuardSelectors...)
+			if *explain {
+				ri.Explanations = explainSelectors(c, explained)
+			}
+			if *suggest {
+				ri.Suggestions = suggestSelectors(c, explained)
+			}
+			// Resolved metric names are informational only and are
+			// reported along with other findings.
+			if ri.ParseError == "" && len(ri.NoResultSelectors) < 1 && len(ri.EmptyFilterSelectors) < 1 && len(ri.EmptyGuardSelectors) < 1 && len(ri.EmptyFallbackSelectors) < 1 && len(ri.InconclusiveSelectors) < 1 && len(ri.NotCurrentSelectors) < 1 && len(ri.UnexpandedSelectors) < 1 && len(ri.NeverExistedSelectors) < 1 && len(ri.DeadExclusions) < 1 && len(ri.Warnings) < 1 && len(ri.HealthProblems) < 1 {
+				continue
+			}
+			results = append(results, ri)
 		}
 	}
+	return results, nil
+}
 
+// hasFindings returns true if any of the given results should cause a
+// non-zero exit code.
+func hasFindings(results []resultItem) bool {
+	for _, r := range results {
+		if r.ParseError != "" || len(r.NoResultSelectors) > 0 || len(r.EmptyFilterSelectors) > 0 || len(r.EmptyGuardSelectors) > 0 || len(r.NeverExistedSelectors) > 0 || len(r.HealthProblems) > 0 || len(r.DeadExclusions) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// printResults prints the given results in the configured output format.
+func printResults(results []resultItem) {
 	switch *outputFormat {
 	case "human":
 		for _, r := range results {
-			fmt.Printf("%s -> %s -> %s\n", r.File, r.Group, r.Name)
+			file := r.File
+			if r.Line > 0 {
+				file = fmt.Sprintf("%s:%d", r.File, r.Line)
+			}
+			if r.Tenant != "" {
+				file = fmt.Sprintf("%s -> %s", r.Tenant, file)
+			}
+			if r.Server != "" {
+				file = fmt.Sprintf("%s -> %s", r.Server, file)
+			}
+			fmt.Printf("%s -> %s -> %s\n", file, r.Group, r.Name)
 			fmt.Printf("  PromQL: %s\n", r.Query)
-			fmt.Print("  Selectors with no results:\n")
-			for _, selector := range r.NoResultSelectors {
-				fmt.Printf("    - %s\n", selector)
+			if r.ParseError != "" {
+				fmt.Printf("  Parse error: %s\n", r.ParseError)
+			}
+			printNoResultSelectors(r, "Selectors with no results", r.NoResultSelectors)
+			printNoResultSelectors(r, "Filters with no results (right-hand side of and, the query never yields results)", r.EmptyFilterSelectors)
+			printNoResultSelectors(r, "Guards with no results (right-hand side of unless, the guard never applies)", r.EmptyGuardSelectors)
+			printNoResultSelectors(r, "Fallbacks with no results (operand of or, not a problem by itself)", r.EmptyFallbackSelectors)
+			if len(r.InconclusiveSelectors) > 0 {
+				fmt.Print("  Selectors with inconclusive results (partial response):\n")
+				for _, selector := range r.InconclusiveSelectors {
+					fmt.Printf("    - %s\n", selector)
+				}
+			}
+			if len(r.NotCurrentSelectors) > 0 {
+				fmt.Print("  Selectors with results in the lookback window, but not right now:\n")
+				for _, selector := range r.NotCurrentSelectors {
+					fmt.Printf("    - %s\n", selector)
+				}
+			}
+			if len(r.UnexpandedSelectors) > 0 {
+				fmt.Print("  Selectors with regexps which could not be expanded (checked as a whole):\n")
+				for _, selector := range r.UnexpandedSelectors {
+					fmt.Printf("    - %s\n", selector)
+				}
+			}
+			if len(r.NeverExistedSelectors) > 0 {
+				fmt.Print("  Selectors within absent() whose metric has never existed (always absent):\n")
+				for _, selector := range r.NeverExistedSelectors {
+					fmt.Printf("    - %s\n", selector)
+				}
+			}
+			if len(r.DeadExclusions) > 0 {
+				fmt.Print("  Exclusions which match no series (dead code):\n")
+				for _, selector := range sortedKeys(r.DeadExclusions) {
+					fmt.Printf("    - %s: %s\n", selector, strings.Join(r.DeadExclusions[selector], ", "))
+				}
+			}
+			if len(r.MetricNames) > 0 {
+				fmt.Print("  Metric names matched by selectors without literal metric name:\n")
+				for _, selector := range sortedKeys(r.MetricNames) {
+					fmt.Printf("    - %s: %s\n", selector, formatMetricNames(r.MetricNames[selector]))
+				}
+			}
+			if len(r.Warnings) > 0 {
+				fmt.Print("  Warnings:\n")
+				for _, selector := range sortedKeys(r.Warnings) {
+					for _, w := range r.Warnings[selector] {
+						fmt.Printf("    - %s: %s\n", selector, w)
+					}
+				}
+			}
+			if len(r.HealthProblems) > 0 {
+				fmt.Print("  Health problems:\n")
+				for _, problem := range r.HealthProblems {
+					fmt.Printf("    - %s\n", problem)
+				}
 			}
 			fmt.Printf("\n")
 		}
 	case "csv":
-		fmt.Printf("File;Group;Name;Query;Problematic selector\n")
+		fmt.Printf("File;Group;Name;Query;Problematic selector;Line;Tenant;Result;Server;Details;Suggestions\n")
 		for _, r := range results {
-			for _, selector := range r.NoResultSelectors {
-				fmt.Printf("%s;%s;%s;%s;%v\n", r.File, r.Group, r.Name, r.Query, selector)
+			if r.ParseError != "" {
+				fmt.Printf("%s;%s;%s;%s;;%d;%s;%s;%s;%s;\n", r.File, r.Group, r.Name, r.Query, r.Line, r.Tenant, "parse error", r.Server, r.ParseError)
+			}
+			noResults := []struct {
+				result    string
+				selectors []string
+			}{
+				{"no results", r.NoResultSelectors},
+				{"empty filter", r.EmptyFilterSelectors},
+				{"empty guard", r.EmptyGuardSelectors},
+				{"empty fallback", r.EmptyFallbackSelectors},
+			}
+			for _, n := range noResults {
+				for _, selector := range n.selectors {
+					fmt.Printf("%s;%s;%s;%s;%v;%d;%s;%s;%s;%s;%s\n", r.File, r.Group, r.Name, r.Query, selector, r.Line, r.Tenant, n.result, r.Server, r.Explanations[selector], strings.Join(r.Suggestions[selector], ", "))
+				}
+			}
+			for _, selector := range r.InconclusiveSelectors {
+				fmt.Printf("%s;%s;%s;%s;%v;%d;%s;%s;%s;;\n", r.File, r.Group, r.Name, r.Query, selector, r.Line, r.Tenant, "inconclusive", r.Server)
+			}
+			for _, selector := range r.NotCurrentSelectors {
+				fmt.Printf("%s;%s;%s;%s;%v;%d;%s;%s;%s;;\n", r.File, r.Group, r.Name, r.Query, selector, r.Line, r.Tenant, "not current", r.Server)
+			}
+			for _, selector := range r.UnexpandedSelectors {
+				fmt.Printf("%s;%s;%s;%s;%v;%d;%s;%s;%s;;\n", r.File, r.Group, r.Name, r.Query, selector, r.Line, r.Tenant, "not expanded", r.Server)
+			}
+			for _, selector := range r.NeverExistedSelectors {
+				fmt.Printf("%s;%s;%s;%s;%v;%d;%s;%s;%s;;\n", r.File, r.Group, r.Name, r.Query, selector, r.Line, r.Tenant, "never existed", r.Server)
+			}
+			for _, selector := range sortedKeys(r.DeadExclusions) {
+				for _, exclusion := range r.DeadExclusions[selector] {
+					fmt.Printf("%s;%s;%s;%s;%v;%d;%s;%s;%s;%s;\n", r.File, r.Group, r.Name, r.Query, selector, r.Line, r.Tenant, "dead exclusion", r.Server, exclusion)
+				}
+			}
+			for _, selector := range sortedKeys(r.MetricNames) {
+				fmt.Printf("%s;%s;%s;%s;%v;%d;%s;%s;%s;%s;\n", r.File, r.Group, r.Name, r.Query, selector, r.Line, r.Tenant, "metric names", r.Server, strings.Join(r.MetricNames[selector], ", "))
+			}
+			for _, selector := range sortedKeys(r.Warnings) {
+				for _, w := range r.Warnings[selector] {
+					fmt.Printf("%s;%s;%s;%s;%v;%d;%s;%s;%s;%s;\n", r.File, r.Group, r.Name, r.Query, selector, r.Line, r.Tenant, "warning", r.Server, w)
+				}
+			}
+			for _, problem := range r.HealthProblems {
+				fmt.Printf("%s;%s;%s;%s;;%d;%s;%s;%s;%s;\n", r.File, r.Group, r.Name, r.Query, r.Line, r.Tenant, "unhealthy", r.Server, problem)
 			}
 		}
 	case "json":
@@ -132,8 +470,38 @@ func checkRules() bool {
 	default:
 		log.WithFields(log.Fields{"outputFormat": *outputFormat}).Fatal("unsupported output format")
 	}
+}
 
-	return len(results) > 0
+// printNoResultSelectors prints the given selectors without results of the
+// given result in the human output format, including explanations and
+// suggestions.
+func printNoResultSelectors(r resultItem, title string, selectors []string) {
+	if len(selectors) < 1 {
+		return
+	}
+	fmt.Printf("  %s:\n", title)
+	for _, selector := range selectors {
+		fmt.Printf("    - %s\n", selector)
+		if e, ok := r.Explanations[selector]; ok {
+			fmt.Printf("      %s\n", e)
+		}
+		if s, ok := r.Suggestions[selector]; ok {
+			fmt.Printf("      %s\n", formatSuggestions(s))
+		}
+	}
+}
+
+// filterIgnoredSelectors returns the given selectors without those matching
+// --ignored-selectors.regexp.
+func filterIgnoredSelectors(selectors []string) []string {
+	var filtered []string
+	for _, selector := range selectors {
+		if isSelectorIgnored(selector) {
+			continue
+		}
+		filtered = append(filtered, selector)
+	}
+	return filtered
 }
 
 func isSelectorIgnored(selector string) bool {
@@ -155,7 +523,18 @@ func isSelectorIgnored(selector string) bool {
 
 // visitor struct is used to collect selectors from a PromQL expression.
 type visitor struct {
-	selectors []string
+	selectors []selectorRef
+}
+
+// selectorRef is a selector together with the context it is used in.
+type selectorRef struct {
+	selector string
+	// absent is set for selectors within absent() or absent_over_time(),
+	// which are expected to yield no results most of the time.
+	absent bool
+	role   selectorRole
+	// ors contains the operands of or operators the selector is part of.
+	ors []orOperand
 }
 
 // Visit is called by promql.Walk when traversing a PromQL expression's syntax tree.
@@ -170,64 +549,240 @@ func (v *visitor) Visit(node promql.Node, path []promql.Node) (promql.Visitor, e
 			Name:          n.Name,
 			LabelMatchers: n.LabelMatchers,
 		}
-		v.selectors = append(v.selectors, vs.String())
+		v.selectors = append(v.selectors, selectorRef{selector: vs.String(), absent: isWithinAbsent(path), role: getSelectorRole(n, path), ors: getOrOperands(n, path)})
 	default:
 		log.Debugf("Not handling %T", n)
 	}
 	return v, nil
 }
 
+// isWithinAbsent returns true if the given path contains a call of absent()
+// or absent_over_time().
+func isWithinAbsent(path []promql.Node) bool {
+	for _, n := range path {
+		if call, ok := n.(*promql.Call); ok && (call.Func.Name == "absent" || call.Func.Name == "absent_over_time") {
+			return true
+		}
+	}
+	return false
+}
+
+// selectorResults contains the problematic selectors of a query, grouped by
+// the outcome of their check.
+type selectorResults struct {
+	// noResults contains the required selectors without results. Those
+	// used as filter, guard or fallback by set operators are contained in
+	// emptyFilters, emptyGuards and emptyFallbacks instead.
+	noResults      []string
+	emptyFilters   []string
+	emptyGuards    []string
+	emptyFallbacks []string
+	inconclusive   []string
+	notCurrent     []string
+	// unexpanded contains the selectors with regexps which could not be
+	// expanded and have therefore been checked as a whole.
+	unexpanded []string
+	// neverExisted contains the selectors within absent() whose metric
+	// has never existed, so that absent() is always true.
+	neverExisted []string
+}
+
 // getNoResultSelectors parses the given query and ensures that all contained
 // selectors yield results by querying the Prometheus API.
-func getNoResultSelectors(query string) []string {
-	var noResultSelectors []string
-	selectors, err := getSelectors(query)
+// See checkSelector for the possible outcomes.
+func getNoResultSelectors(c apiClient, query string) (selectorResults, error) {
+	results, err := getNoResultSelectorsForQueries(c, []string{query})
+	if err != nil {
+		return selectorResults{}, err
+	}
+	return results[0], nil
+}
+
+// getNoResultSelectorsForQueries works like getNoResultSelectors for
+// multiple queries at once, so that their selectors can be checked together
+// (e.g. in batches).
+func getNoResultSelectorsForQueries(c apiClient, queries []string) ([]selectorResults, error) {
+	pending := make([][]pendingSelector, len(queries))
+	absent := make([][]selectorRef, len(queries))
+	var checked []string
+	for i, query := range queries {
+		var err error
+		pending[i], absent[i], err = getPendingSelectors(c, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pending[i] {
+			if !p.missing {
+				checked = append(checked, p.selector)
+			}
+		}
+	}
+	statuses, err := checkSelectors(c, checked)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]selectorResults, len(queries))
+	for i := range queries {
+		var absentSelectors []string
+		for _, ref := range absent[i] {
+			absentSelectors = append(absentSelectors, ref.selector)
+		}
+		results[i].neverExisted, err = getNeverExistingSelectors(c, absentSelectors)
+		if err != nil {
+			return nil, err
+		}
+		queryStatuses := make([]selectorStatus, len(pending[i]))
+		for j, p := range pending[i] {
+			queryStatuses[j] = selectorNoResults
+			if !p.missing {
+				queryStatuses[j], statuses = statuses[0], statuses[1:]
+			}
+		}
+		operands := getOrOperandResults(pending[i], queryStatuses, absent[i])
+		for j, p := range pending[i] {
+			selector := p.selector
+			if *expandRegexps {
+				unexpanded, err := hasUnexpandedRegexp(selector)
+				if err != nil {
+					return nil, err
+				}
+				if unexpanded {
+					results[i].unexpanded = append(results[i].unexpanded, selector)
+				}
+			}
+			status := queryStatuses[j]
+			if status == selectorNoResults && p.role == roleOptional && !operands.hasFallback(p.ors) {
+				// All operands of the or are empty, so that the
+				// query yields no results.
+				p.role = roleRequired
+			}
+			switch {
+			case status == selectorNoResults && p.role == roleFilter:
+				results[i].emptyFilters = append(results[i].emptyFilters, selector)
+			case status == selectorNoResults && p.role == roleGuard:
+				results[i].emptyGuards = append(results[i].emptyGuards, selector)
+			case status == selectorNoResults && p.role == roleOptional:
+				results[i].emptyFallbacks = append(results[i].emptyFallbacks, selector)
+			case status == selectorNoResults:
+				results[i].noResults = append(results[i].noResults, selector)
+			case status == selectorInconclusive:
+				results[i].inconclusive = append(results[i].inconclusive, selector)
+			case status == selectorNotCurrent:
+				results[i].notCurrent = append(results[i].notCurrent, selector)
+			}
+		}
+	}
+	return results, nil
+}
+
+// pendingSelector is a selector which has to be checked.
+type pendingSelector struct {
+	selectorRef
+	// missing is set if the selector's metric is known to be missing, so
+	// that it must not be queried.
+	missing bool
+}
+
+// getPendingSelectors returns all selectors of the given query which have to
+// be checked, in order, with regexp matchers expanded if enabled.
+// Selectors within absent() are expected to be empty and therefore returned
+// separately in absent.
+func getPendingSelectors(c apiClient, query string) (pending []pendingSelector, absent []selectorRef, err error) {
+	refs, err := getSelectorRefs(query)
 	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Fatal("getSelectors failed")
+		return nil, nil, err
 	}
-	log.WithFields(log.Fields{"len(selectors)": len(selectors)}).Debug("Found selectors")
+	log.WithFields(log.Fields{"len(selectors)": len(refs)}).Debug("Found selectors")
 
-	var selector string
+	var selectors []selectorRef
+	for _, ref := range refs {
+		if ref.absent {
+			absent = append(absent, ref)
+		} else {
+			selectors = append(selectors, ref)
+		}
+	}
+
+	var ref selectorRef
 	for len(selectors) > 0 {
-		selector, selectors = selectors[0], selectors[1:]
+		ref, selectors = selectors[0], selectors[1:]
+		selector := ref.selector
 		log.WithFields(log.Fields{"selector": selector}).Debug("Checking selector")
 		matchers, err := promql.ParseMetricSelector(selector)
 		if err != nil {
-			log.WithFields(log.Fields{"err": err}).Fatal("Metric selector parsing failed")
+			return nil, nil, fmt.Errorf("metric selector parsing failed: %s", err)
 		}
 		if ignoreMatchers(matchers) {
 			log.WithFields(log.Fields{"selector": selector}).Debug("Not checking ignored metric")
 			break
 		}
+		if *prefetchMetricNames && !metricExists(c, matchers) {
+			skipped := countExpandedSelectors(matchers)
+			log.WithFields(log.Fields{"selector": selector, "skippedQueries": skipped}).Debug("Metric does not exist, not querying")
+			stats.add(&stats.skippedQueries, uint64(skipped))
+			pending = append(pending, pendingSelector{selectorRef: ref, missing: true})
+			continue
+		}
 		if *expandRegexps {
 			expanded := expandRegexpMatchers(matchers)
 			if len(expanded) != 0 {
 				for _, e := range expanded {
-					selectors = append(selectors, labelMatchersToString(e))
+					expandedRef := ref
+					expandedRef.selector = labelMatchersToString(e)
+					selectors = append(selectors, expandedRef)
 				}
 				continue
 			}
 		}
-		time.Sleep(time.Duration(*waitTime) * time.Second)
-		c := getResultCount(selector)
-		if c < 1 {
-			noResultSelectors = append(noResultSelectors, selector)
+		pending = append(pending, pendingSelector{selectorRef: ref})
+	}
+	return pending, absent, nil
+}
+
+// getNeverExistingSelectors returns those of the given selectors whose
+// metric name is not known to the server at all, i.e. has never existed
+// within its retention. Only selectors with a literal metric name are
+// checked.
+func getNeverExistingSelectors(c apiClient, selectors []string) ([]string, error) {
+	var neverExisted []string
+	for _, selector := range selectors {
+		matchers, err := promql.ParseMetricSelector(selector)
+		if err != nil {
+			return nil, fmt.Errorf("metric selector parsing failed: %s", err)
+		}
+		if ignoreMatchers(matchers) {
+			continue
+		}
+		if !metricExists(c, matchers) {
+			log.WithFields(log.Fields{"selector": selector}).Debug("Metric within absent() has never existed")
+			neverExisted = append(neverExisted, selector)
 		}
 	}
-	return noResultSelectors
+	return neverExisted, nil
 }
 
 // ignoreMatchers returns true if the given metric should be
-// ignored.
+// ignored. Metric name regexps are ignored if they only match ignored metrics.
 func ignoreMatchers(matchers []*labels.Matcher) bool {
 	for _, m := range matchers {
 		log.WithFields(log.Fields{"m": m}).Debug("Matcher")
 		if m.Name != "__name__" {
 			continue
 		}
-		if m.Value == "ALERTS" || m.Value == "ALERTS_FOR_STATE" {
+		var names []string
+		switch m.Type {
+		case labels.MatchEqual:
+			names = []string{m.Value}
+		case labels.MatchRegexp:
+			names, _ = expandRegexp(m.Value, *expandMax)
+		}
+		ignored := len(names) > 0
+		for _, name := range names {
 			// Those are temporary, internal metrics which may generate
 			// false positives.
+			ignored = ignored && (name == "ALERTS" || name == "ALERTS_FOR_STATE")
+		}
+		if ignored {
 			return true
 		}
 	}
@@ -236,38 +791,37 @@ func ignoreMatchers(matchers []*labels.Matcher) bool {
 
 // expandRegexpMatchers walks the given list of label matchers and
 // attempts to expand the first found expandable regexp matcher.
-// A regexp matcher is expandable if it is a simple list of alternatives in the
-// form a|b|c.
+// A regexp matcher is expandable if it matches a finite number (at least two,
+// at most --expand.max) of strings, e.g. a|b|c, (prod|stage)-db, foo(-bar)?
+// or 5[0-9][0-9].
 // foo=~"a|b|c" will be turned into
-//   foo=~"a"
-//   foo=~"b"
-//   foo=~"c"
-// This function is supposed to handle the common, trivial case. It is not
-// able to handle arbitrarily complex regexp.
+//
+//	foo=~"a"
+//	foo=~"b"
+//	foo=~"c"
 func expandRegexpMatchers(matchers []*labels.Matcher) [][]*labels.Matcher {
 	expanded := make([][]*labels.Matcher, 0)
-	var alternatives []string
-	for _, m := range matchers {
+	for i, m := range matchers {
 		if m.Type != labels.MatchRegexp {
 			continue
 		}
-		if strings.ContainsAny(m.Value, "()\\") {
+		literals, ok := expandRegexp(m.Value, *expandMax)
+		if !ok || len(literals) < 2 {
 			continue
 		}
-		alternatives = strings.Split(m.Value, "|")
-		if len(alternatives) < 2 {
-			continue
-		}
-		candidateLabel := m.Name
-		for _, alt := range alternatives {
+		for _, literal := range literals {
 			e := make([]*labels.Matcher, len(matchers))
-			for i, n := range matchers {
-				var mCopy labels.Matcher
-				if n.Name == candidateLabel && n.Type == labels.MatchRegexp {
-					n.Value = alt
+			for j, n := range matchers {
+				mCopy := *n
+				if j == i && n.Name == labels.MetricName {
+					// Expanded metric names are turned into
+					// equality matchers, so that they are handled
+					// like any other metric name.
+					mCopy = *labels.MustNewMatcher(labels.MatchEqual, n.Name, literal)
+				} else if j == i {
+					mCopy.Value = regexp.QuoteMeta(literal)
 				}
-				mCopy = *n
-				e[i] = &mCopy
+				e[j] = &mCopy
 			}
 			expanded = append(expanded, e)
 		}
@@ -278,30 +832,20 @@ func expandRegexpMatchers(matchers []*labels.Matcher) [][]*labels.Matcher {
 
 // getResultCount queries the Prometheus API and counts the number of results
 // for the given selector.
-func getResultCount(selector string) uint64 {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/query", *url), nil)
-	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Fatal("Query request init failed")
-	}
-	count := fmt.Sprintf("count(%s)", selector)
-	q := req.URL.Query()
-	q.Add("query", count)
-	req.URL.RawQuery = q.Encode()
-	client := http.Client{}
-	resp, err := client.Do(req)
-	log.WithFields(log.Fields{"resp": resp, "err": err}).Debug("rule query result")
+// Warnings returned by the API are passed on, as they may indicate an
+// incomplete result.
+func getResultCount(c apiClient, selector string) (uint64, []string, error) {
+	params := url.Values{}
+	params.Add("query", fmt.Sprintf("count(%s)", selector))
+	b, err := c.get("/api/v1/query", params)
 	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Fatal("Rule request failed")
-	}
-
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Fatal("Body reading failed")
+		return 0, nil, fmt.Errorf("query request failed: %s", err)
 	}
 
 	j := struct {
-		Status string
-		Data   struct {
+		Status   string
+		Warnings []string
+		Data     struct {
 			Result []struct {
 				Metric map[string]string
 				Value  []interface{}
@@ -310,20 +854,20 @@ func getResultCount(selector string) uint64 {
 	}{}
 	err = json.Unmarshal(b, &j)
 	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Fatal("json parsing failed")
+		return 0, nil, fmt.Errorf("json parsing failed: %s", err)
 	}
 
 	if j.Status != "success" {
-		log.WithFields(log.Fields{"status": j.Status}).Fatal("Unexpected status in rule request")
+		return 0, nil, fmt.Errorf("unexpected status %q in query request", j.Status)
 	}
 	if len(j.Data.Result) != 1 {
-		return 0
+		return 0, j.Warnings, nil
 	}
 	i, err := strconv.ParseUint(j.Data.Result[0].Value[1].(string), 10, 64)
 	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Fatal("Int conversion failed")
+		return 0, nil, fmt.Errorf("int conversion failed: %s", err)
 	}
-	return i
+	return i, j.Warnings, nil
 }
 
 // getSelectors parses the given PromQL query and extracts
@@ -334,6 +878,20 @@ func getResultCount(selector string) uint64 {
 //   foo{a="1"}
 //   bar{a="2"}
 func getSelectors(query string) ([]string, error) {
+	refs, err := getSelectorRefs(query)
+	if err != nil {
+		return nil, err
+	}
+	selectors := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		selectors = append(selectors, ref.selector)
+	}
+	return selectors, nil
+}
+
+// getSelectorRefs works like getSelectors, but additionally returns the
+// context each selector is used in.
+func getSelectorRefs(query string) ([]selectorRef, error) {
 	expr, err := promql.ParseExpr(query)
 	if err != nil {
 		log.WithFields(log.Fields{"err": err}).Debug("ParseExpr")
@@ -341,7 +899,7 @@ func getSelectors(query string) ([]string, error) {
 	}
 	log.Debug(promql.Tree(expr))
 	v := &visitor{
-		selectors: make([]string, 0),
+		selectors: make([]selectorRef, 0),
 	}
 	var path []promql.Node
 	promql.Walk(v, expr, path)
@@ -353,7 +911,7 @@ func getSelectors(query string) ([]string, error) {
 func labelMatchersToString(lms []*labels.Matcher) string {
 	name := ""
 	for _, lm := range lms {
-		if lm.Name == labels.MetricName {
+		if lm.Name == labels.MetricName && lm.Type == labels.MatchEqual {
 			name = lm.Value
 		}
 	}
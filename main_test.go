@@ -46,7 +46,39 @@ func TestGetSelectors(t *testing.T) {
 	}
 }
 
+func TestGetSelectorRefs(t *testing.T) {
+	c := map[string][]selectorRef{
+		"absent(up{job=\"a\"})": {
+			{selector: "up{job=\"a\"}", absent: true},
+		},
+		"absent_over_time(up[5m]) or sum(rate(foo[5m]))": {
+			{selector: "up", absent: true, role: roleOptional},
+			{selector: "foo", role: roleOptional},
+		},
+		"up == 0 unless absent(bar)": {
+			{selector: "up"},
+			{selector: "bar", absent: true, role: roleGuard},
+		},
+	}
+	for q, e := range c {
+		r, err := getSelectorRefs(q)
+		if err != nil {
+			t.Errorf("%v", err)
+		}
+		// or operands are checked by TestGetOrOperands.
+		for i := range r {
+			r[i].ors = nil
+		}
+		if !reflect.DeepEqual(r, e) {
+			t.Errorf("%s: %v != %v", q, r, e)
+		}
+	}
+}
+
 func TestExpandRegexpMatchers(t *testing.T) {
+	max := *expandMax
+	defer func() { *expandMax = max }()
+	*expandMax = 256
 	c := []struct {
 		i string
 		o []string
@@ -75,14 +107,65 @@ func TestExpandRegexpMatchers(t *testing.T) {
 		},
 		{
 			i: "foo{bar=~\"(a|b|c)\"}",
-			o: []string{},
+			o: []string{
+				"foo{bar=~\"a\"}",
+				"foo{bar=~\"b\"}",
+				"foo{bar=~\"c\"}",
+			},
 		},
 		{
 			i: "foo{bar=~\"(a)|b|c\"}",
-			o: []string{},
+			o: []string{
+				"foo{bar=~\"a\"}",
+				"foo{bar=~\"b\"}",
+				"foo{bar=~\"c\"}",
+			},
 		},
 		{
 			i: "foo{bar=~\"a\\\\|b|c\"}",
+			o: []string{
+				"foo{bar=~\"a\\\\|b\"}",
+				"foo{bar=~\"c\"}",
+			},
+		},
+		{
+			i: "{__name__=~\"foo|bar\",job=\"a\"}",
+			o: []string{
+				"{__name__=\"foo\",job=\"a\"}",
+				"{__name__=\"bar\",job=\"a\"}",
+			},
+		},
+		{
+			i: "foo{env=~\"(prod|stage)-db\"}",
+			o: []string{
+				"foo{env=~\"prod-db\"}",
+				"foo{env=~\"stage-db\"}",
+			},
+		},
+		{
+			i: "foo{bar=~\"foo(-bar)?\"}",
+			o: []string{
+				"foo{bar=~\"foo\"}",
+				"foo{bar=~\"foo-bar\"}",
+			},
+		},
+		{
+			i: "foo{code=~\"5[0-1][0-2]\"}",
+			o: []string{
+				"foo{code=~\"500\"}",
+				"foo{code=~\"501\"}",
+				"foo{code=~\"502\"}",
+				"foo{code=~\"510\"}",
+				"foo{code=~\"511\"}",
+				"foo{code=~\"512\"}",
+			},
+		},
+		{
+			i: "foo{bar=~\"a.b|c\"}",
+			o: []string{},
+		},
+		{
+			i: "foo{code=~\"[0-9][0-9][0-9]\"}",
 			o: []string{},
 		},
 		{
@@ -134,6 +217,8 @@ func TestLabelMatchersToString(t *testing.T) {
 		"foo",
 		"foo{bar=\"baz\"}",
 		"foo{bar=\"baz\",z=\"1\"}",
+		"{__name__=~\"foo|bar\",z=\"1\"}",
+		"{z=\"1\"}",
 	}
 	for _, i := range c {
 		parsedI, err := promql.ParseMetricSelector(i)
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/prometheus/model/labels"
+	promql "github.com/prometheus/prometheus/promql/parser"
+)
+
+// resolveMetricNames resolves the metric names of all selectors of the given
+// query which do not have a literal metric name, i.e. which use a metric
+// name regexp (e.g. {__name__=~"node_(cpu|memory)_.*"}) or no metric name at
+// all (e.g. {job="x"}).
+// It returns the metric names each of these selectors currently matches and
+// warnings about regexp alternatives matching no metric name and about
+// nameless selectors matching more than --nameless.max-series series.
+func resolveMetricNames(c apiClient, query string) (map[string][]string, map[string][]string, error) {
+	selectors, err := getSelectors(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := map[string][]string{}
+	warnings := map[string][]string{}
+	for _, selector := range selectors {
+		matchers, err := promql.ParseMetricSelector(selector)
+		if err != nil {
+			return nil, nil, fmt.Errorf("metric selector parsing failed: %s", err)
+		}
+		if ignoreMatchers(matchers) || isSelectorIgnored(selector) {
+			continue
+		}
+		var nameMatchers []*labels.Matcher
+		for _, m := range matchers {
+			if m.Name == labels.MetricName {
+				nameMatchers = append(nameMatchers, m)
+			}
+		}
+		if len(nameMatchers) == 0 {
+			n, ok, w := resolveNamelessSelector(c, selector)
+			if ok {
+				names[selector] = n
+			}
+			warnings[selector] = append(warnings[selector], w...)
+			if len(warnings[selector]) == 0 {
+				delete(warnings, selector)
+			}
+			continue
+		}
+		isRegexp := false
+		for _, m := range nameMatchers {
+			isRegexp = isRegexp || m.Type == labels.MatchRegexp
+		}
+		if !isRegexp {
+			continue
+		}
+		mc, err := getMetricCatalog(c)
+		if err != nil {
+			warnings[selector] = append(warnings[selector], fmt.Sprintf("failed to retrieve metric names: %s", err))
+			continue
+		}
+		names[selector] = matchMetricNames(nameMatchers, mc.names)
+		for _, m := range nameMatchers {
+			if m.Type != labels.MatchRegexp {
+				continue
+			}
+			for _, alt := range deadRegexpAlternatives(m.Value, mc.names) {
+				warnings[selector] = append(warnings[selector], fmt.Sprintf("alternative %q of %s matches no metric name", alt, m))
+			}
+		}
+	}
+	if len(names) == 0 {
+		names = nil
+	}
+	if len(warnings) == 0 {
+		warnings = nil
+	}
+	return names, warnings, nil
+}
+
+// namelessSelectorEntry is the cached resolution of a selector without metric
+// name. Concurrent users of the same entry wait for the first resolution.
+type namelessSelectorEntry struct {
+	once     sync.Once
+	names    []string
+	resolved bool
+	warnings []string
+}
+
+var (
+	namelessSelectorsMtx sync.Mutex
+	namelessSelectors    = map[string]*namelessSelectorEntry{}
+)
+
+// resolveNamelessSelector returns the metric names the given selector without
+// metric name currently matches and warnings if it matches more than
+// --nameless.max-series series (if enabled) or the server could not be
+// queried. ok is false if the metric names could not be retrieved.
+// Selectors are resolved only once per run.
+func resolveNamelessSelector(c apiClient, selector string) (names []string, ok bool, warnings []string) {
+	key := c.baseURL + "|" + c.header.Get("X-Scope-OrgID") + "|" + selector
+	namelessSelectorsMtx.Lock()
+	e, ok := namelessSelectors[key]
+	if !ok {
+		e = &namelessSelectorEntry{}
+		namelessSelectors[key] = e
+	}
+	namelessSelectorsMtx.Unlock()
+	e.once.Do(func() {
+		names, err := getLabelValues(c, labels.MetricName, []string{selector})
+		if err != nil {
+			e.warnings = append(e.warnings, fmt.Sprintf("failed to retrieve metric names: %s", err))
+		} else {
+			e.names, e.resolved = names, true
+		}
+		if *namelessMaxSeries <= 0 {
+			return
+		}
+		count, _, err := getResultCount(c, selector)
+		if err != nil {
+			e.warnings = append(e.warnings, fmt.Sprintf("failed to count series: %s", err))
+		} else if count > uint64(*namelessMaxSeries) {
+			e.warnings = append(e.warnings, fmt.Sprintf("selector without metric name matches %d series", count))
+		}
+	})
+	return e.names, e.resolved, e.warnings
+}
+
+// matchMetricNames returns all of the given metric names matched by all of
+// the given matchers.
+func matchMetricNames(matchers []*labels.Matcher, names []string) []string {
+	matched := []string{}
+	for _, name := range names {
+		ok := true
+		for _, m := range matchers {
+			ok = ok && m.Matches(name)
+		}
+		if ok {
+			matched = append(matched, name)
+		}
+	}
+	return matched
+}
+
+// maxDeadRegexpAlternatives is the maximum number of alternatives of a
+// single alternation checked by deadRegexpAlternatives.
+const maxDeadRegexpAlternatives = 64
+
+// deadRegexpAlternatives returns the alternatives of all alternations in
+// the given regexp which match none of the given names when used instead of
+// their alternation, e.g. memory for node_(cpu|memory)_.* if there is no
+// node_memory_ metric. Alternatives nested in dead alternatives are not
+// returned.
+func deadRegexpAlternatives(value string, names []string) []string {
+	re, err := syntax.Parse(value, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	var dead []string
+	choices := map[*syntax.Regexp]*syntax.Regexp{}
+	var walk func(n *syntax.Regexp)
+	walk = func(n *syntax.Regexp) {
+		alternatives := splitRegexpAlternatives(n, maxDeadRegexpAlternatives)
+		if len(alternatives) < 2 {
+			for _, sub := range n.Sub {
+				walk(sub)
+			}
+			return
+		}
+		for _, alt := range alternatives {
+			choices[n] = alt
+			if !matchesAnyName(substituteRegexp(re, choices).String(), names) {
+				dead = append(dead, formatRegexp(alt))
+				continue
+			}
+			walk(alt)
+		}
+		delete(choices, n)
+	}
+	walk(re)
+	return dead
+}
+
+// substituteRegexp returns a copy of the given regexp in which all
+// sub-expressions contained in choices are replaced by the chosen ones.
+func substituteRegexp(re *syntax.Regexp, choices map[*syntax.Regexp]*syntax.Regexp) *syntax.Regexp {
+	if c, ok := choices[re]; ok && c != re {
+		return substituteRegexp(c, choices)
+	}
+	if len(re.Sub) == 0 {
+		return re
+	}
+	cp := *re
+	cp.Sub = make([]*syntax.Regexp, len(re.Sub))
+	for i, sub := range re.Sub {
+		cp.Sub[i] = substituteRegexp(sub, choices)
+	}
+	return &cp
+}
+
+// formatRegexp returns the source of the given regexp for reporting. The
+// flag group which syntax.Regexp.String adds for dots (e.g. (?-s:.*)) is
+// removed, as metric names cannot contain newlines.
+func formatRegexp(re *syntax.Regexp) string {
+	s := re.String()
+	for _, flags := range []string{"(?-s:", "(?s:"} {
+		if !strings.HasPrefix(s, flags) || !strings.HasSuffix(s, ")") {
+			continue
+		}
+		// The group may not span the whole string, e.g. (?s:a)|(?s:b),
+		// in which case the remainder does not parse.
+		inner := s[len(flags) : len(s)-1]
+		if _, err := syntax.Parse(inner, syntax.Perl); err == nil {
+			return inner
+		}
+	}
+	return s
+}
+
+// matchesAnyName returns true if the given (fully anchored) regexp matches any
+// of the given names.
+func matchesAnyName(expr string, names []string) bool {
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return true
+	}
+	for _, name := range names {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxFormattedMetricNames is the maximum number of metric names listed in
+// human-readable output.
+const maxFormattedMetricNames = 10
+
+// formatMetricNames formats the given metric names for human-readable output.
+func formatMetricNames(names []string) string {
+	if len(names) == 0 {
+		return "no metric names"
+	}
+	if len(names) > maxFormattedMetricNames {
+		return fmt.Sprintf("%s and %d more", strings.Join(names[:maxFormattedMetricNames], ", "), len(names)-maxFormattedMetricNames)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestDeadRegexpAlternatives(t *testing.T) {
+	names := []string{"node_cpu_seconds_total", "node_load1", "up", "B"}
+	c := map[string][]string{
+		"node_(cpu|memory)_.*":   {"memory"},
+		"up|node_load(1|5|15)":   {"5", "15"},
+		"node_.*":                nil,
+		"(foo|bar)_.*|up":        {"(foo|bar)_.*"},
+		"up|upx|up_.*":           {"upx", "up_.*"},
+		"node_load1|node_load15": {"node_load15"},
+		"(?i)a|b":                nil,
+		"(?i)up|foo":             {"(?i:FOO)"},
+	}
+	for re, e := range c {
+		r := deadRegexpAlternatives(re, names)
+		if !reflect.DeepEqual(r, e) {
+			t.Errorf("%s: %q != %q", re, r, e)
+		}
+	}
+}
+
+func TestResolveMetricNames(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			if r.URL.Query().Get("match[]") == `{job="node"}` {
+				fmt.Fprint(w, `{"status":"success","data":["node_load1","up"]}`)
+				return
+			}
+			fmt.Fprint(w, `{"status":"success","data":["node_cpu_seconds_total","node_load1","up"]}`)
+		case "/api/v1/query":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1,"20000"]}]}}`)
+		default:
+			t.Errorf("unexpected request: %v", r.URL)
+		}
+	}))
+	defer ts.Close()
+
+	max := *namelessMaxSeries
+	defer func() { *namelessMaxSeries = max }()
+	*namelessMaxSeries = 10000
+	names, warnings, err := resolveMetricNames(newAPIClient(ts.URL, nil), `{__name__=~"node_(cpu|memory)_.*"} + {job="node"} + up`)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	e := map[string][]string{
+		`{__name__=~"node_(cpu|memory)_.*"}`: {"node_cpu_seconds_total"},
+		`{job="node"}`:                       {"node_load1", "up"},
+	}
+	if !reflect.DeepEqual(names, e) {
+		t.Errorf("%v != %v", names, e)
+	}
+	e = map[string][]string{
+		`{__name__=~"node_(cpu|memory)_.*"}`: {`alternative "memory" of __name__=~"node_(cpu|memory)_.*" matches no metric name`},
+		`{job="node"}`:                       {"selector without metric name matches 20000 series"},
+	}
+	if !reflect.DeepEqual(warnings, e) {
+		t.Errorf("%v != %v", warnings, e)
+	}
+}
+
+func TestResolveNamelessSelectorFailure(t *testing.T) {
+	queries := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			fmt.Fprint(w, `{"status":"success","data":["up"]}`)
+		case "/api/v1/query":
+			queries++
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"status":"error","error":"query limit exceeded"}`)
+		}
+	}))
+	defer ts.Close()
+
+	max := *namelessMaxSeries
+	defer func() { *namelessMaxSeries = max }()
+	*namelessMaxSeries = 10000
+	c := newAPIClient(ts.URL, nil)
+	for i := 0; i < 2; i++ {
+		names, ok, warnings := resolveNamelessSelector(c, `{job="x"}`)
+		if !ok || !reflect.DeepEqual(names, []string{"up"}) || len(warnings) != 1 {
+			t.Errorf("unexpected result: %v, %v", names, warnings)
+		}
+	}
+	// Selectors are resolved only once.
+	if queries != 1 {
+		t.Errorf("%d queries != 1", queries)
+	}
+}
+
+func TestResolveMetricNamesFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprint(w, `{"status":"error","error":"query limit exceeded"}`)
+	}))
+	defer ts.Close()
+
+	names, warnings, err := resolveMetricNames(newAPIClient(ts.URL, nil), `{job="failing"} + {__name__=~"failing_.*"}`)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if names != nil {
+		t.Errorf("unexpected metric names: %v", names)
+	}
+	if len(warnings[`{job="failing"}`]) != 1 || len(warnings[`{__name__=~"failing_.*"}`]) != 1 {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+}
+
+func TestCheckRulesMetricNamesOnly(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			fmt.Fprint(w, `{"status":"success","data":["up"]}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1,"1"]}]}}`)
+		}
+	}))
+	defer ts.Close()
+
+	resolve := *resolveNames
+	defer func() { *resolveNames = resolve }()
+	*resolveNames = true
+	r, err := checkRules(ts.URL, []ruleGroup{{Name: "g", Rules: []rule{{Name: "r", Query: `{job="names-only"}`}}}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(r) != 0 {
+		t.Errorf("rule reported only due to metric names: %+v", r)
+	}
+}
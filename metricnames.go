@@ -0,0 +1,137 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/prometheus/model/labels"
+	log "github.com/sirupsen/logrus"
+)
+
+// metricCatalog contains the names and (optionally) metadata of all metrics
+// known to a server.
+type metricCatalog struct {
+	names        []string
+	exists       map[string]bool
+	metadata     map[string][]metricMetadata
+	metadataOnce sync.Once
+}
+
+// metricCatalogEntry is a cached metric catalog or the error which occurred
+// while retrieving it. Concurrent users of the same entry wait for the first
+// retrieval, while other servers and tenants are not blocked.
+type metricCatalogEntry struct {
+	once sync.Once
+	mc   *metricCatalog
+	err  error
+}
+
+var (
+	metricCatalogsMtx sync.Mutex
+	metricCatalogs    = map[string]*metricCatalogEntry{}
+)
+
+// getMetricCatalog retrieves the metric names for the server and tenants of
+// the given client. Catalogs are retrieved only once per run, failures are
+// not retried.
+func getMetricCatalog(c apiClient) (*metricCatalog, error) {
+	key := c.baseURL + "|" + c.header.Get("X-Scope-OrgID")
+	metricCatalogsMtx.Lock()
+	e, ok := metricCatalogs[key]
+	if !ok {
+		e = &metricCatalogEntry{}
+		metricCatalogs[key] = e
+	}
+	metricCatalogsMtx.Unlock()
+	e.once.Do(func() {
+		log.WithFields(log.Fields{"server": c.baseURL}).Debug("Retrieving metric names")
+		names, err := getLabelValues(c, labels.MetricName, nil)
+		if err != nil {
+			log.WithFields(log.Fields{"server": c.baseURL, "err": err}).Warn("Failed to retrieve metric names")
+			e.err = err
+			return
+		}
+		e.mc = newMetricCatalog(names, nil)
+	})
+	return e.mc, e.err
+}
+
+// getMetricCatalogWithMetadata works like getMetricCatalog, but additionally
+// retrieves the metric metadata. Metadata is optional, as not all servers
+// support the metadata API.
+func getMetricCatalogWithMetadata(c apiClient) (*metricCatalog, error) {
+	mc, err := getMetricCatalog(c)
+	if err != nil {
+		return nil, err
+	}
+	mc.metadataOnce.Do(func() {
+		mc.metadata, err = getMetadata(c)
+		if err != nil {
+			log.WithFields(log.Fields{"server": c.baseURL, "err": err}).Warn("Failed to retrieve metric metadata")
+		}
+	})
+	return mc, nil
+}
+
+// newMetricCatalog returns a metricCatalog for the given metric names and
+// metadata.
+func newMetricCatalog(names []string, metadata map[string][]metricMetadata) *metricCatalog {
+	mc := &metricCatalog{names: names, exists: map[string]bool{}, metadata: metadata}
+	for _, n := range names {
+		mc.exists[n] = true
+	}
+	return mc
+}
+
+// help returns the HELP text of the given metric, if known.
+func (mc *metricCatalog) help(name string) string {
+	for _, m := range mc.metadata[name] {
+		if m.Help != "" {
+			return m.Help
+		}
+	}
+	return ""
+}
+
+// metricType returns the type of the given metric, if known.
+func (mc *metricCatalog) metricType(name string) string {
+	for _, m := range mc.metadata[name] {
+		if m.Type != "" {
+			return m.Type
+		}
+	}
+	return ""
+}
+
+// metricExists returns false if the given selector's metric name is known
+// not to exist on the server. If the selector has no literal metric name or
+// the metric names cannot be retrieved, it is assumed to exist.
+func metricExists(c apiClient, matchers []*labels.Matcher) bool {
+	metric := ""
+	for _, m := range matchers {
+		if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+			metric = m.Value
+		}
+	}
+	if metric == "" {
+		return true
+	}
+	mc, err := getMetricCatalog(c)
+	return err != nil || mc.exists[metric]
+}
+
+// countExpandedSelectors returns the number of selectors which would be
+// queried for the given selector, taking regexp expansion into account.
+func countExpandedSelectors(matchers []*labels.Matcher) int {
+	if !*expandRegexps {
+		return 1
+	}
+	expanded := expandRegexpMatchers(matchers)
+	if len(expanded) == 0 {
+		return 1
+	}
+	n := 0
+	for _, e := range expanded {
+		n += countExpandedSelectors(e)
+	}
+	return n
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetNoResultSelectorsPrefetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			fmt.Fprint(w, `{"status":"success","data":["present"]}`)
+		case "/api/v1/query":
+			if r.URL.Query().Get("query") != "count(present)" {
+				t.Errorf("unexpected query: %v", r.URL)
+			}
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1,"1"]}]}}`)
+		default:
+			t.Errorf("unexpected request: %v", r.URL)
+		}
+	}))
+	defer ts.Close()
+
+	prefetch, expand, max := *prefetchMetricNames, *expandRegexps, *expandMax
+	defer func() { *prefetchMetricNames, *expandRegexps, *expandMax = prefetch, expand, max }()
+	*prefetchMetricNames, *expandRegexps, *expandMax = true, true, 256
+	skipped := stats.skippedQueries
+	r, err := getNoResultSelectors(newAPIClient(ts.URL, nil), `present + missing{a=~"x|y"}`)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	e := []string{`missing{a=~"x|y"}`}
+	if !reflect.DeepEqual(r.noResults, e) {
+		t.Errorf("%v != %v", r.noResults, e)
+	}
+	if d := stats.skippedQueries - skipped; d != 2 {
+		t.Errorf("skipped queries: %d != 2", d)
+	}
+}
+
+func TestGetNoResultSelectorsAbsent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			fmt.Fprint(w, `{"status":"success","data":["up"]}`)
+		default:
+			t.Errorf("unexpected request: %v", r.URL)
+		}
+	}))
+	defer ts.Close()
+
+	r, err := getNoResultSelectors(newAPIClient(ts.URL, nil), `absent(up{job="a"}) or absent_over_time(upp[5m])`)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(r.noResults) > 0 {
+		t.Errorf("unexpected selectors with no results: %v", r.noResults)
+	}
+	e := []string{"upp"}
+	if !reflect.DeepEqual(r.neverExisted, e) {
+		t.Errorf("%v != %v", r.neverExisted, e)
+	}
+}
+
+func TestGetMetricCatalogConcurrency(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+			t.Errorf("request to other server has been blocked")
+		}
+		fmt.Fprint(w, `{"status":"success","data":["up"]}`)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(release)
+		fmt.Fprint(w, `{"status":"success","data":["up"]}`)
+	}))
+	defer fast.Close()
+
+	done := make(chan struct{})
+	go func() {
+		getMetricCatalog(newAPIClient(slow.URL, nil))
+		close(done)
+	}()
+	// Wait until the request to slow is pending.
+	time.Sleep(100 * time.Millisecond)
+	getMetricCatalog(newAPIClient(fast.URL, nil))
+	<-done
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// getRuleGroupsFromMimir retrieves the rule groups of all given tenants from
+// the Mimir or Cortex ruler at the given URL.
+func getRuleGroupsFromMimir(server string, tenants []string) ([]ruleGroup, error) {
+	var groups []ruleGroup
+	for _, tenant := range tenants {
+		log.WithFields(log.Fields{"tenant": tenant}).Debug("Retrieving rules")
+		c := newAPIClient(server, []string{tenant})
+		var g []ruleGroup
+		var err error
+		switch *mimirRulesAPI {
+		case "prometheus":
+			g, err = getRuleGroupsFromAPI(c)
+		case "config":
+			g, err = getRuleGroupsFromMimirConfig(c)
+		default:
+			err = fmt.Errorf("unsupported rules API %q", *mimirRulesAPI)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %s", tenant, err)
+		}
+		for i := range g {
+			g[i].Tenant = tenant
+		}
+		groups = append(groups, g...)
+	}
+	return groups, nil
+}
+
+// withTenants returns the given rule groups once for each of the given
+// tenants, so that rules from sources other than the ruler (e.g. rule files)
+// are checked against each tenant's data. Groups which already have a tenant
+// are returned unchanged.
+func withTenants(groups []ruleGroup, tenants []string) []ruleGroup {
+	if len(tenants) == 0 {
+		return groups
+	}
+	var result []ruleGroup
+	for _, g := range groups {
+		if g.Tenant != "" {
+			result = append(result, g)
+			continue
+		}
+		for _, tenant := range tenants {
+			g.Tenant = tenant
+			result = append(result, g)
+		}
+	}
+	return result
+}
+
+// getRuleGroupsFromMimirConfig retrieves all rule groups using the ruler's
+// configuration API. In contrast to the Prometheus-style API, this includes
+// Mimir-specific group fields such as source_tenants.
+func getRuleGroupsFromMimirConfig(c apiClient) ([]ruleGroup, error) {
+	b, err := c.get("/config/v1/rules", nil)
+	if errors.Is(err, errNotFound) {
+		// The ruler responds with 404 if a tenant has no rules.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rule request failed: %s", err)
+	}
+	groups, err := parseMimirConfigRules(b)
+	if err != nil {
+		return nil, fmt.Errorf("yaml parsing failed: %s", err)
+	}
+	return groups, nil
+}
+
+// parseMimirConfigRules parses the response of the ruler's configuration
+// API, which contains lists of rule groups keyed by namespace.
+// The resulting groups are labelled with their namespace.
+func parseMimirConfigRules(b []byte) ([]ruleGroup, error) {
+	var namespaces map[string][]ruleFileGroup
+	err := yaml.Unmarshal(b, &namespaces)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for ns := range namespaces {
+		names = append(names, ns)
+	}
+	sort.Strings(names)
+	var groups []ruleGroup
+	for _, ns := range names {
+		groups = append(groups, ruleFile{Groups: namespaces[ns]}.ruleGroups(ns)...)
+	}
+	// Line numbers would refer to the API response.
+	clearLines(groups)
+	return groups, nil
+}
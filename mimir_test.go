@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMimirConfigRules(t *testing.T) {
+	f := `team-b:
+- name: federated
+  source_tenants: [tenant-a, tenant-b]
+  rules:
+  - alert: InstanceDown
+    expr: up == 0
+team-a:
+- name: base
+  rules:
+  - record: job:up:sum
+    expr: sum by (job) (up)
+`
+	g, err := parseMimirConfigRules([]byte(f))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	e := []ruleGroup{
+		{
+			Name:  "base",
+			File:  "team-a",
+			Rules: []rule{{Name: "job:up:sum", Query: "sum by (job) (up)", Type: "recording"}},
+		},
+		{
+			Name:          "federated",
+			File:          "team-b",
+			SourceTenants: []string{"tenant-a", "tenant-b"},
+			Rules:         []rule{{Name: "InstanceDown", Query: "up == 0", Type: "alerting"}},
+		},
+	}
+	if !reflect.DeepEqual(g, e) {
+		t.Errorf("%v != %v", g, e)
+	}
+}
+
+func TestNewAPIClientTenants(t *testing.T) {
+	c := map[string][]string{
+		"":                  nil,
+		"tenant-a":          {"tenant-a"},
+		"tenant-a|tenant-b": {"tenant-a", "tenant-b"},
+	}
+	for e, tenants := range c {
+		h := newAPIClient("http://127.0.0.1:9009/prometheus", tenants).header.Get("X-Scope-OrgID")
+		if h != e {
+			t.Errorf("%v: %s != %s", tenants, h, e)
+		}
+	}
+}
+
+func TestWithTenants(t *testing.T) {
+	groups := []ruleGroup{
+		{Name: "file", File: "rules.yml"},
+		{Name: "ruler", Tenant: "tenant-c"},
+	}
+	if r := withTenants(groups, nil); !reflect.DeepEqual(r, groups) {
+		t.Errorf("%v != %v", r, groups)
+	}
+	e := []ruleGroup{
+		{Name: "file", File: "rules.yml", Tenant: "tenant-a"},
+		{Name: "file", File: "rules.yml", Tenant: "tenant-b"},
+		{Name: "ruler", Tenant: "tenant-c"},
+	}
+	if r := withTenants(groups, []string{"tenant-a", "tenant-b"}); !reflect.DeepEqual(r, e) {
+		t.Errorf("%v != %v", r, e)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// prometheusRule is the structure of a Prometheus Operator PrometheusRule
+// object. spec has the same structure as a standard rule file.
+type prometheusRule struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"metadata"`
+	Spec ruleFile `yaml:"spec"`
+}
+
+// getRuleGroupsFromPrometheusRules reads all Kubernetes manifests matching
+// the given glob patterns and extracts the rule groups from all contained
+// PrometheusRule objects.
+func getRuleGroupsFromPrometheusRules(patterns []string) []ruleGroup {
+	var groups []ruleGroup
+	for _, path := range globFiles(patterns) {
+		log.WithFields(log.Fields{"path": path}).Debug("Reading manifest file")
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.WithFields(log.Fields{"path": path, "err": err}).Fatal("Manifest file reading failed")
+		}
+		g, err := parsePrometheusRules(b)
+		if err != nil {
+			log.WithFields(log.Fields{"path": path, "err": err}).Fatal("Manifest file parsing failed")
+		}
+		groups = append(groups, g...)
+	}
+	return groups
+}
+
+// parsePrometheusRules parses the given (possibly multi-document) YAML
+// content and returns the rule groups of all PrometheusRule objects.
+// Other objects are skipped.
+// The resulting groups are labelled with the object's namespace/name.
+func parsePrometheusRules(b []byte) ([]ruleGroup, error) {
+	var groups []ruleGroup
+	d := yaml.NewDecoder(bytes.NewReader(b))
+	for {
+		var pr prometheusRule
+		err := d.Decode(&pr)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if pr.Kind != "PrometheusRule" || pr.APIVersion != "monitoring.coreos.com/v1" {
+			log.WithFields(log.Fields{"apiVersion": pr.APIVersion, "kind": pr.Kind, "name": pr.Metadata.Name}).Debug("Skipping object")
+			continue
+		}
+		g := pr.Spec.ruleGroups(fmt.Sprintf("%s/%s", pr.Metadata.Namespace, pr.Metadata.Name))
+		// Line numbers would refer to the manifest file, which is not
+		// part of the label and therefore misleading.
+		clearLines(g)
+		groups = append(groups, g...)
+	}
+	return groups, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePrometheusRules(t *testing.T) {
+	f := `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: unrelated
+---
+apiVersion: monitoring.coreos.com/v1
+kind: PrometheusRule
+metadata:
+  name: node
+  namespace: monitoring
+spec:
+  groups:
+  - name: base
+    rules:
+    - alert: InstanceDown
+      expr: up == 0
+---
+apiVersion: monitoring.coreos.com/v1
+kind: PrometheusRule
+metadata:
+  name: app
+  namespace: team-a
+spec:
+  groups:
+  - name: app
+    rules:
+    - record: job:requests:rate5m
+      expr: sum by (job) (rate(requests_total[5m]))
+`
+	g, err := parsePrometheusRules([]byte(f))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	e := []ruleGroup{
+		{
+			Name:  "base",
+			File:  "monitoring/node",
+			Rules: []rule{{Name: "InstanceDown", Query: "up == 0", Type: "alerting"}},
+		},
+		{
+			Name:  "app",
+			File:  "team-a/app",
+			Rules: []rule{{Name: "job:requests:rate5m", Query: "sum by (job) (rate(requests_total[5m]))", Type: "recording"}},
+		},
+	}
+	if !reflect.DeepEqual(g, e) {
+		t.Errorf("%v != %v", g, e)
+	}
+}
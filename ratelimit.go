@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rateLimiter is an adaptive token bucket limiting the request rate to a
+// server. The rate starts at (and never exceeds) the configured maximum.
+// It is halved whenever the server signals overload (429/503) and reduced
+// when the request latency rises considerably. Otherwise it slowly recovers.
+type rateLimiter struct {
+	mtx        sync.Mutex
+	rate       float64
+	maxRate    float64
+	minRate    float64
+	burst      float64
+	tokens     float64
+	last       time.Time
+	latency    time.Duration
+	minLatency time.Duration
+}
+
+var (
+	rateLimitersMtx sync.Mutex
+	rateLimiters    = map[string]*rateLimiter{}
+)
+
+// getRateLimiter returns the rate limiter for the given server, which is
+// shared by all requests to it. It returns nil if --wait.seconds is not
+// positive, i.e. if the request rate is not limited.
+func getRateLimiter(server string) *rateLimiter {
+	if *waitTime <= 0 {
+		return nil
+	}
+	rateLimitersMtx.Lock()
+	defer rateLimitersMtx.Unlock()
+	if l, ok := rateLimiters[server]; ok {
+		return l
+	}
+	l := newRateLimiter(1 / *waitTime, *queryConcurrency)
+	rateLimiters[server] = l
+	return l
+}
+
+// newRateLimiter returns a rate limiter allowing up to maxRate requests per
+// second with bursts of the given size.
+func newRateLimiter(maxRate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:    maxRate,
+		maxRate: maxRate,
+		minRate: maxRate / 100,
+		burst:   float64(burst),
+		tokens:  float64(burst),
+		last:    time.Now(),
+	}
+}
+
+// wait blocks until a request may be sent.
+func (l *rateLimiter) wait() {
+	if l == nil {
+		return
+	}
+	for {
+		l.mtx.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mtx.Unlock()
+			return
+		}
+		d := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mtx.Unlock()
+		time.Sleep(d)
+	}
+}
+
+// observe adapts the rate to the given request outcome.
+func (l *rateLimiter) observe(latency time.Duration, statusCode int) {
+	if l == nil {
+		return
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if isOverloaded(statusCode) {
+		l.setRate(l.rate / 2)
+		log.WithFields(log.Fields{"statusCode": statusCode, "rate": l.rate}).Debug("Server overloaded, reducing request rate")
+		return
+	}
+	if l.minLatency == 0 || latency < l.minLatency {
+		l.minLatency = latency
+	}
+	if l.latency == 0 {
+		l.latency = latency
+	}
+	l.latency = (4*l.latency + latency) / 5
+	if l.latency > 4*l.minLatency && l.latency > 100*time.Millisecond {
+		l.setRate(l.rate * 0.9)
+		log.WithFields(log.Fields{"latency": l.latency, "rate": l.rate}).Debug("Latency rising, reducing request rate")
+		return
+	}
+	l.setRate(l.rate + l.maxRate/20)
+}
+
+// setRate sets the rate, limited to the allowed range.
+func (l *rateLimiter) setRate(rate float64) {
+	if rate > l.maxRate {
+		rate = l.maxRate
+	}
+	if rate < l.minRate {
+		rate = l.minRate
+	}
+	l.rate = rate
+}
+
+// isOverloaded returns true if the given status code signals that the
+// server is overloaded and the request should be retried later.
+func isOverloaded(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable
+}
+
+// forEachIndex calls f for 0 <= i < n using up to concurrency goroutines
+// and waits for all calls to finish.
+func forEachIndex(n, concurrency int, f func(i int)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAdaptation(t *testing.T) {
+	l := newRateLimiter(10, 1)
+	l.observe(10*time.Millisecond, http.StatusTooManyRequests)
+	if l.rate != 5 {
+		t.Errorf("rate after 429: %v != 5", l.rate)
+	}
+	for i := 0; i < 10; i++ {
+		l.observe(10*time.Millisecond, http.StatusServiceUnavailable)
+	}
+	if l.rate != 0.1 {
+		t.Errorf("rate after repeated 503: %v != 0.1", l.rate)
+	}
+	for i := 0; i < 30; i++ {
+		l.observe(10*time.Millisecond, http.StatusOK)
+	}
+	if l.rate != 10 {
+		t.Errorf("rate after recovery: %v != 10", l.rate)
+	}
+	l.observe(time.Second, http.StatusOK)
+	if l.rate >= 10 {
+		t.Errorf("rate after latency increase: %v >= 10", l.rate)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	l := newRateLimiter(100, 2)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		l.wait()
+	}
+	// The first two requests are allowed immediately (burst).
+	if d := time.Since(start); d < 35*time.Millisecond {
+		t.Errorf("6 requests at 100/s took only %v", d)
+	}
+}
+
+func TestAPIClientRetry(t *testing.T) {
+	var mtx sync.Mutex
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		requests++
+		if requests <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"status":"success","data":["up"]}`)
+	}))
+	defer ts.Close()
+
+	v, err := getLabelValues(newAPIClient(ts.URL, nil), "__name__", nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !reflect.DeepEqual(v, []string{"up"}) || requests != 3 {
+		t.Errorf("%v after %d requests", v, requests)
+	}
+}
+
+func TestForEachIndexOrder(t *testing.T) {
+	r := make([]int, 20)
+	forEachIndex(len(r), 4, func(i int) {
+		time.Sleep(time.Duration(20-i) * time.Millisecond)
+		r[i] = i * i
+	})
+	for i := range r {
+		if r[i] != i*i {
+			t.Errorf("%d: %d != %d", i, r[i], i*i)
+		}
+	}
+}
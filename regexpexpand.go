@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+
+	"github.com/prometheus/prometheus/model/labels"
+	promql "github.com/prometheus/prometheus/promql/parser"
+)
+
+// caseInsensitiveFlagRe matches flag groups enabling case-insensitive
+// matching, e.g. (?i) or (?si:...).
+var caseInsensitiveFlagRe = regexp.MustCompile(`\(\?[msU]*i`)
+
+// expandRegexp returns all strings the given (fully anchored) regexp matches,
+// in the order of the regexp. ok is false if the regexp matches infinitely
+// many or more than max strings or cannot be parsed.
+// Case-insensitive regexps are not expanded, as their case variants are not
+// distinct values worth checking.
+func expandRegexp(value string, max int) (literals []string, ok bool) {
+	re, err := syntax.Parse(value, syntax.Perl)
+	if err != nil || caseInsensitiveFlagRe.MatchString(value) {
+		return nil, false
+	}
+	return expandRegexpSyntax(re.Simplify(), max)
+}
+
+// expandRegexpAlternatives works like expandRegexp, but expands each
+// alternative on its own, skipping those which cannot be expanded.
+// E.g. tmpfs|nfs.* yields tmpfs and nfs|nfs4|nfsd.* yields nfs and nfs4.
+func expandRegexpAlternatives(value string, max int) []string {
+	re, err := syntax.Parse(value, syntax.Perl)
+	if err != nil || caseInsensitiveFlagRe.MatchString(value) {
+		return nil
+	}
+	var literals []string
+	for _, alt := range splitRegexpAlternatives(re, max) {
+		sub, ok := expandRegexpSyntax(alt.Simplify(), max)
+		if !ok {
+			continue
+		}
+		literals, ok = appendUnique(literals, sub, max)
+		if !ok {
+			return nil
+		}
+	}
+	return literals
+}
+
+// maxSplitCharClass is the maximum number of characters of a character class
+// which is split into alternatives by splitRegexpAlternatives. syntax.Parse
+// merges single character alternatives (e.g. 1|5) into such classes.
+const maxSplitCharClass = 8
+
+// splitRegexpAlternatives returns the alternatives of the given parsed
+// regexp. syntax.Parse factors out common prefixes of alternatives, e.g.
+// nfs|nfs4|nfsd.* is parsed as nfs(?:|4|d.*). This is undone by
+// distributing concatenations over the alternations they contain, so that
+// the example yields nfs, nfs4 and nfsd.* again. Alternations within
+// capturing groups of a concatenation (e.g. node_(cpu|memory)) are not
+// split. The regexp itself is returned if it has no alternatives or more
+// than max.
+func splitRegexpAlternatives(re *syntax.Regexp, max int) []*syntax.Regexp {
+	switch re.Op {
+	case syntax.OpCapture:
+		return splitRegexpAlternatives(re.Sub[0], max)
+	case syntax.OpAlternate:
+		var alternatives []*syntax.Regexp
+		for _, s := range re.Sub {
+			alternatives = append(alternatives, splitRegexpAlternatives(s, max)...)
+			if len(alternatives) > max {
+				return []*syntax.Regexp{re}
+			}
+		}
+		return alternatives
+	case syntax.OpCharClass:
+		if !isSplittableCharClass(re) {
+			return []*syntax.Regexp{re}
+		}
+		var alternatives []*syntax.Regexp
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				alternatives = append(alternatives, &syntax.Regexp{Op: syntax.OpLiteral, Flags: re.Flags, Rune: []rune{r}})
+			}
+		}
+		return alternatives
+	case syntax.OpConcat:
+		products := [][]*syntax.Regexp{nil}
+		for _, s := range re.Sub {
+			parts := []*syntax.Regexp{s}
+			if s.Op == syntax.OpAlternate || s.Op == syntax.OpCharClass {
+				parts = splitRegexpAlternatives(s, max)
+			}
+			if len(products)*len(parts) > max {
+				return []*syntax.Regexp{re}
+			}
+			var next [][]*syntax.Regexp
+			for _, prefix := range products {
+				for _, p := range parts {
+					seq := append([]*syntax.Regexp{}, prefix...)
+					switch p.Op {
+					case syntax.OpEmptyMatch:
+					case syntax.OpConcat:
+						seq = append(seq, p.Sub...)
+					default:
+						seq = append(seq, p)
+					}
+					next = append(next, seq)
+				}
+			}
+			products = next
+		}
+		if len(products) == 1 {
+			return []*syntax.Regexp{re}
+		}
+		var alternatives []*syntax.Regexp
+		for _, seq := range products {
+			switch len(seq) {
+			case 0:
+				alternatives = append(alternatives, &syntax.Regexp{Op: syntax.OpEmptyMatch, Flags: re.Flags})
+			case 1:
+				alternatives = append(alternatives, seq[0])
+			default:
+				alternatives = append(alternatives, &syntax.Regexp{Op: syntax.OpConcat, Flags: re.Flags, Sub: seq})
+			}
+		}
+		return alternatives
+	}
+	return []*syntax.Regexp{re}
+}
+
+// isSplittableCharClass returns true if the given character class is small
+// enough and case-sensitive, so that its characters can be treated as
+// alternatives.
+func isSplittableCharClass(re *syntax.Regexp) bool {
+	if re.Flags&syntax.FoldCase != 0 {
+		return false
+	}
+	n := 0
+	for i := 0; i+1 < len(re.Rune); i += 2 {
+		n += int(re.Rune[i+1]-re.Rune[i]) + 1
+		if n > maxSplitCharClass {
+			return false
+		}
+	}
+	return n > 1
+}
+
+// expandRegexpSyntax implements expandRegexp for a parsed regexp.
+func expandRegexpSyntax(re *syntax.Regexp, max int) ([]string, bool) {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+		// Prometheus anchors all regexps, so anchors do not change the
+		// matched strings.
+		return []string{""}, true
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil, false
+		}
+		return []string{string(re.Rune)}, true
+	case syntax.OpCharClass:
+		var literals []string
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			if int(re.Rune[i+1]-re.Rune[i])+1+len(literals) > max {
+				return nil, false
+			}
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				literals = append(literals, string(r))
+			}
+		}
+		return literals, true
+	case syntax.OpCapture:
+		return expandRegexpSyntax(re.Sub[0], max)
+	case syntax.OpQuest:
+		sub, ok := expandRegexpSyntax(re.Sub[0], max)
+		if !ok {
+			return nil, false
+		}
+		return appendUnique([]string{""}, sub, max)
+	case syntax.OpAlternate:
+		var literals []string
+		for _, s := range re.Sub {
+			sub, ok := expandRegexpSyntax(s, max)
+			if !ok {
+				return nil, false
+			}
+			literals, ok = appendUnique(literals, sub, max)
+			if !ok {
+				return nil, false
+			}
+		}
+		return literals, true
+	case syntax.OpConcat:
+		literals := []string{""}
+		for _, s := range re.Sub {
+			sub, ok := expandRegexpSyntax(s, max)
+			if !ok || len(literals)*len(sub) > max {
+				return nil, false
+			}
+			var product []string
+			for _, prefix := range literals {
+				for _, suffix := range sub {
+					product = append(product, prefix+suffix)
+				}
+			}
+			literals, _ = appendUnique(nil, product, max)
+		}
+		return literals, true
+	}
+	// Repetitions (*, +, unbounded {n,}), wildcards and word boundaries.
+	return nil, false
+}
+
+// appendUnique appends all values which are not contained in literals yet.
+// It fails if the result would contain more than max values.
+func appendUnique(literals, values []string, max int) ([]string, bool) {
+	seen := map[string]bool{}
+	for _, l := range literals {
+		seen[l] = true
+	}
+	for _, v := range values {
+		if seen[v] {
+			continue
+		}
+		if len(literals) >= max {
+			return nil, false
+		}
+		seen[v] = true
+		literals = append(literals, v)
+	}
+	return literals, true
+}
+
+// hasUnexpandedRegexp returns true if the given selector contains a regexp
+// matcher which looks like a set of specific values (i.e. contains an
+// alternation), but cannot be expanded. Such a selector can only be checked
+// as a whole, so some of the values may not exist without being reported.
+func hasUnexpandedRegexp(selector string) (bool, error) {
+	matchers, err := promql.ParseMetricSelector(selector)
+	if err != nil {
+		return false, fmt.Errorf("metric selector parsing failed: %s", err)
+	}
+	for _, m := range matchers {
+		if m.Type != labels.MatchRegexp {
+			continue
+		}
+		re, err := syntax.Parse(m.Value, syntax.Perl)
+		if err != nil || !hasAlternation(re) {
+			continue
+		}
+		if _, ok := expandRegexp(m.Value, *expandMax); !ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// hasAlternation returns true if the given regexp contains an alternation.
+func hasAlternation(re *syntax.Regexp) bool {
+	if re.Op == syntax.OpAlternate {
+		return true
+	}
+	for _, s := range re.Sub {
+		if hasAlternation(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"regexp/syntax"
+	"testing"
+)
+
+func TestExpandRegexp(t *testing.T) {
+	c := []struct {
+		re  string
+		max int
+		e   []string
+		ok  bool
+	}{
+		{re: "a|b|a", max: 10, e: []string{"a", "b"}, ok: true},
+		{re: "^(foo|bar)$", max: 10, e: []string{"foo", "bar"}, ok: true},
+		{re: "x(a|b)(1|2)", max: 10, e: []string{"xa1", "xa2", "xb1", "xb2"}, ok: true},
+		{re: "x(a|b)(1|2)", max: 3, ok: false},
+		{re: "a{2,3}", max: 10, e: []string{"aa", "aaa"}, ok: true},
+		{re: `a\.b`, max: 10, e: []string{"a.b"}, ok: true},
+		{re: "a|", max: 10, e: []string{"a", ""}, ok: true},
+		{re: "a+", max: 10, ok: false},
+		{re: "a.*", max: 10, ok: false},
+		{re: `\bfoo`, max: 10, ok: false},
+		{re: "(", max: 10, ok: false},
+		{re: "(?i)node", max: 100, ok: false},
+		{re: "(?i)a|b", max: 10, ok: false},
+		{re: "a|(?i:b)", max: 10, ok: false},
+		{re: "(?-i)a|b", max: 10, e: []string{"a", "b"}, ok: true},
+	}
+	for _, x := range c {
+		r, ok := expandRegexp(x.re, x.max)
+		if ok != x.ok || !reflect.DeepEqual(r, x.e) {
+			t.Errorf("%s: %v, %v != %v, %v", x.re, r, ok, x.e, x.ok)
+		}
+	}
+}
+
+func TestSplitRegexpAlternatives(t *testing.T) {
+	c := map[string][]string{
+		"a":                    {"a"},
+		"nfs|nfs4|nfsd.*":      {"nfs", "nfs4", "(?-s:nfsd.*)"},
+		"up|node_load(1|5|15)": {"up", "node_load([15]|15)"},
+		"(1|5|15)":             {"1", "5", "15"},
+		"foo_a|foo_b":          {"foo_a", "foo_b"},
+		"(?i)a|b":              {"[ABab]"},
+		"node_[0-9]+":          {"node_[0-9]+"},
+	}
+	for re, e := range c {
+		parsed, err := syntax.Parse(re, syntax.Perl)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		var r []string
+		for _, alt := range splitRegexpAlternatives(parsed, 10) {
+			r = append(r, alt.String())
+		}
+		if !reflect.DeepEqual(r, e) {
+			t.Errorf("%s: %q != %q", re, r, e)
+		}
+	}
+}
+
+func TestExpandRegexpAlternatives(t *testing.T) {
+	c := map[string][]string{
+		"tmpfs|nfs.*":     {"tmpfs"},
+		"nfs|nfs4|nfsd.*": {"nfs", "nfs4"},
+		"(?i)node":        nil,
+	}
+	for re, e := range c {
+		if r := expandRegexpAlternatives(re, 10); !reflect.DeepEqual(r, e) {
+			t.Errorf("%s: %q != %q", re, r, e)
+		}
+	}
+}
+
+func TestHasUnexpandedRegexp(t *testing.T) {
+	max := *expandMax
+	defer func() { *expandMax = max }()
+	*expandMax = 256
+	c := map[string]bool{
+		`up{job=~"a|b"}`:                false,
+		`up{job=~".+"}`:                 false,
+		`up{job=~"node-.*|prometheus"}`: true,
+		`up{job!~"node-.*|prometheus"}`: false,
+		`up{code=~"(4|5)[0-9][0-9]"}`:   false,
+		`up{env=~"(prod|stage)-.*"}`:    true,
+	}
+	for selector, e := range c {
+		r, err := hasUnexpandedRegexp(selector)
+		if err != nil {
+			t.Errorf("%s: %v", selector, err)
+		}
+		if r != e {
+			t.Errorf("%s: %v != %v", selector, r, e)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// ruleFile is the structure of a standard Prometheus rule file.
+// yaml.Node is used for the rule fields in order to retain line numbers.
+type ruleFile struct {
+	Groups []ruleFileGroup `yaml:"groups"`
+}
+
+// ruleFileGroup is a single group within a rule file.
+// source_tenants is a Mimir extension for federated rule groups.
+type ruleFileGroup struct {
+	Name          string   `yaml:"name"`
+	SourceTenants []string `yaml:"source_tenants"`
+	Rules         []struct {
+		Record yaml.Node `yaml:"record"`
+		Alert  yaml.Node `yaml:"alert"`
+		Expr   yaml.Node `yaml:"expr"`
+	} `yaml:"rules"`
+}
+
+// getRuleGroupsFromFiles reads all rule files matching the given glob
+// patterns.
+func getRuleGroupsFromFiles(patterns []string) []ruleGroup {
+	var groups []ruleGroup
+	for _, path := range globFiles(patterns) {
+		log.WithFields(log.Fields{"path": path}).Debug("Reading rule file")
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.WithFields(log.Fields{"path": path, "err": err}).Fatal("Rule file reading failed")
+		}
+		g, err := parseRuleFile(path, b)
+		if err != nil {
+			log.WithFields(log.Fields{"path": path, "err": err}).Fatal("Rule file parsing failed")
+		}
+		groups = append(groups, g...)
+	}
+	return groups
+}
+
+// globFiles returns all paths matching the given glob patterns.
+// Patterns which do not match any file are considered fatal as they most
+// likely indicate a typo.
+func globFiles(patterns []string) []string {
+	var paths []string
+	for _, pattern := range patterns {
+		p, err := filepath.Glob(pattern)
+		if err != nil {
+			log.WithFields(log.Fields{"pattern": pattern, "err": err}).Fatal("Invalid file pattern")
+		}
+		if len(p) == 0 {
+			log.WithFields(log.Fields{"pattern": pattern}).Fatal("No files found")
+		}
+		paths = append(paths, p...)
+	}
+	return paths
+}
+
+// parseRuleFile parses the given rule file content into rule groups.
+// path is only used for labelling the resulting groups.
+func parseRuleFile(path string, b []byte) ([]ruleGroup, error) {
+	var f ruleFile
+	err := yaml.Unmarshal(b, &f)
+	if err != nil {
+		return nil, err
+	}
+	return f.ruleGroups(path), nil
+}
+
+// ruleGroups converts the parsed rule file into rule groups, using the given
+// file label for all of them.
+func (f ruleFile) ruleGroups(file string) []ruleGroup {
+	var groups []ruleGroup
+	for _, g := range f.Groups {
+		rg := ruleGroup{Name: g.Name, File: file, SourceTenants: g.SourceTenants}
+		for _, r := range g.Rules {
+			rr := rule{Query: r.Expr.Value}
+			if r.Alert.Value != "" {
+				rr.Name, rr.Type, rr.Line = r.Alert.Value, "alerting", r.Alert.Line
+			} else {
+				rr.Name, rr.Type, rr.Line = r.Record.Value, "recording", r.Record.Line
+			}
+			rg.Rules = append(rg.Rules, rr)
+		}
+		groups = append(groups, rg)
+	}
+	return groups
+}
+
+// clearLines resets the line numbers of all rules in the given groups.
+// This is used for sources where line numbers would refer to some
+// intermediate document instead of the rule's real source.
+func clearLines(groups []ruleGroup) {
+	for _, g := range groups {
+		for i := range g.Rules {
+			g.Rules[i].Line = 0
+		}
+	}
+}
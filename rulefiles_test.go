@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRuleFile(t *testing.T) {
+	f := `groups:
+- name: base
+  rules:
+  - record: job:up:sum
+    expr: sum by (job) (up)
+  - alert: InstanceDown
+    expr: |
+      up == 0
+    for: 5m
+`
+	g, err := parseRuleFile("rules.yml", []byte(f))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	e := []ruleGroup{
+		{
+			Name: "base",
+			File: "rules.yml",
+			Rules: []rule{
+				{Name: "job:up:sum", Query: "sum by (job) (up)", Type: "recording", Line: 4},
+				{Name: "InstanceDown", Query: "up == 0\n", Type: "alerting", Line: 6},
+			},
+		},
+	}
+	if !reflect.DeepEqual(g, e) {
+		t.Errorf("%v != %v", g, e)
+	}
+}
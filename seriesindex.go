@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	promql "github.com/prometheus/prometheus/promql/parser"
+	log "github.com/sirupsen/logrus"
+)
+
+// seriesIndexLookback is the time range for which series are retrieved for
+// the series index. It matches Prometheus' default lookback delta, i.e. the
+// range an instant query considers.
+const seriesIndexLookback = 5 * time.Minute
+
+// seriesIndexEntry contains the label sets of all current series of a metric.
+// If the metric has more series than --series-index.limit, series is nil and
+// tooMany is set.
+type seriesIndexEntry struct {
+	series  []map[string]string
+	tooMany bool
+}
+
+// seriesIndexSlot holds the index entry of a metric once it has been
+// retrieved. Concurrent lookups of the same metric wait for the first
+// retrieval, while other metrics are not blocked.
+type seriesIndexSlot struct {
+	once sync.Once
+	e    *seriesIndexEntry
+}
+
+var (
+	seriesIndexMtx   sync.Mutex
+	seriesIndexSlots = map[string]*seriesIndexSlot{}
+)
+
+// lookupSeriesIndex checks whether the given selector matches any current
+// series using the series index. ok is false if the index cannot be used,
+// e.g. because the selector has no metric name, the metric has too many
+// series or the series could not be retrieved.
+func lookupSeriesIndex(c apiClient, selector string) (found, ok bool) {
+	matchers, err := promql.ParseMetricSelector(selector)
+	if err != nil {
+		log.WithFields(log.Fields{"selector": selector, "err": err}).Debug("Metric selector parsing failed, not using series index")
+		return false, false
+	}
+	metric := ""
+	for _, m := range matchers {
+		if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+			metric = m.Value
+		}
+	}
+	if metric == "" {
+		return false, false
+	}
+	e := getSeriesIndexEntry(c, metric)
+	if e == nil || e.tooMany {
+		return false, false
+	}
+	stats.add(&stats.indexedSelectors, 1)
+	for _, s := range e.series {
+		if matchesSeries(matchers, s) {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// getSeriesIndexEntry returns the index entry for the given metric,
+// retrieving its series on first use. It returns nil if the series could not
+// be retrieved.
+func getSeriesIndexEntry(c apiClient, metric string) *seriesIndexEntry {
+	key := c.baseURL + "|" + c.header.Get("X-Scope-OrgID") + "|" + metric
+	seriesIndexMtx.Lock()
+	s, ok := seriesIndexSlots[key]
+	if !ok {
+		s = &seriesIndexSlot{}
+		seriesIndexSlots[key] = s
+	}
+	seriesIndexMtx.Unlock()
+	s.once.Do(func() {
+		s.e = retrieveSeriesIndexEntry(c, metric)
+	})
+	return s.e
+}
+
+// retrieveSeriesIndexEntry retrieves the current series of the given metric.
+// It returns nil if the series could not be retrieved.
+func retrieveSeriesIndexEntry(c apiClient, metric string) *seriesIndexEntry {
+	end := time.Now()
+	// Requesting one more series than the limit tells whether the limit has
+	// been exceeded.
+	series, err := getSeries(c, []string{metric}, end.Add(-seriesIndexLookback), end, *seriesIndexLimit+1)
+	if err != nil {
+		log.WithFields(log.Fields{"metric": metric, "err": err}).Warn("Failed to retrieve series for series index, using queries")
+		return nil
+	}
+	e := &seriesIndexEntry{series: series}
+	if len(series) > *seriesIndexLimit {
+		log.WithFields(log.Fields{"metric": metric, "limit": *seriesIndexLimit}).Debug("Too many series for series index, using queries")
+		e = &seriesIndexEntry{tooMany: true}
+	}
+	return e
+}
+
+// matchesSeries returns true if the given label set matches all matchers.
+// Missing labels are treated as empty, just like Prometheus does.
+func matchesSeries(matchers []*labels.Matcher, series map[string]string) bool {
+	for _, m := range matchers {
+		if !m.Matches(series[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
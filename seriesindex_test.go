@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckSelectorSeriesIndex(t *testing.T) {
+	var seriesRequests, queries int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/series":
+			seriesRequests++
+			switch r.URL.Query().Get("match[]") {
+			case "up":
+				fmt.Fprint(w, `{"status":"success","data":[{"__name__":"up","job":"node","instance":"a"},{"__name__":"up","job":"prometheus"}]}`)
+			case "high_cardinality":
+				fmt.Fprint(w, `{"status":"success","data":[{"__name__":"high_cardinality","id":"1"},{"__name__":"high_cardinality","id":"2"},{"__name__":"high_cardinality","id":"3"}]}`)
+			default:
+				fmt.Fprint(w, `{"status":"success","data":[]}`)
+			}
+		case "/api/v1/query":
+			queries++
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1,"1"]}]}}`)
+		default:
+			t.Errorf("unexpected request: %v", r.URL)
+		}
+	}))
+	defer ts.Close()
+
+	enabled, limit, backend := *seriesIndexEnabled, *seriesIndexLimit, *existenceBackend
+	defer func() { *seriesIndexEnabled, *seriesIndexLimit, *existenceBackend = enabled, limit, backend }()
+	*seriesIndexEnabled, *seriesIndexLimit, *existenceBackend = true, 2, "query"
+	c := newAPIClient(ts.URL, nil)
+	e := map[string]selectorStatus{
+		`up`:                                selectorOK,
+		`up{job="node"}`:                    selectorOK,
+		`up{job=~"node|other"}`:             selectorOK,
+		`up{job="nodee"}`:                   selectorNoResults,
+		`up{instance!=""}`:                  selectorOK,
+		`up{job="prometheus",instance!=""}`: selectorNoResults,
+		`missing`:                           selectorNoResults,
+		`high_cardinality{id="1"}`:          selectorOK,
+	}
+	for selector, status := range e {
+		s, err := checkSelector(c, selector)
+		if err != nil {
+			t.Errorf("%s: %v", selector, err)
+		}
+		if s != status {
+			t.Errorf("%s: %v != %v", selector, s, status)
+		}
+	}
+	if seriesRequests != 3 {
+		t.Errorf("series requests: %d != 3", seriesRequests)
+	}
+	if queries != 1 {
+		t.Errorf("queries: %d != 1", queries)
+	}
+}
+
+func TestGetSeriesIndexEntryConcurrency(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("match[]") {
+		case "slow":
+			select {
+			case <-release:
+			case <-time.After(5 * time.Second):
+				t.Errorf("request for other metric has been blocked")
+			}
+		case "fast":
+			close(release)
+		}
+		fmt.Fprint(w, `{"status":"success","data":[]}`)
+	}))
+	defer ts.Close()
+
+	limit := *seriesIndexLimit
+	defer func() { *seriesIndexLimit = limit }()
+	*seriesIndexLimit = 10
+	c := newAPIClient(ts.URL, nil)
+	done := make(chan struct{})
+	go func() {
+		getSeriesIndexEntry(c, "slow")
+		close(done)
+	}()
+	// Wait until the request for slow is pending.
+	time.Sleep(100 * time.Millisecond)
+	getSeriesIndexEntry(c, "fast")
+	<-done
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	promql "github.com/prometheus/prometheus/promql/parser"
+)
+
+// selectorRole describes how the results of a selector are used by the set
+// operators (and, or, unless) of a query. It determines how severe it is if
+// the selector yields no results.
+type selectorRole int
+
+const (
+	// roleRequired means that the query yields no results if the selector
+	// yields none.
+	roleRequired selectorRole = iota
+	// roleOptional means that the selector is an operand of or, so that
+	// the other operand may provide results instead (fallback).
+	roleOptional
+	// roleGuard means that the selector is on the right-hand side of
+	// unless. Without results, the guard never applies.
+	roleGuard
+	// roleFilter means that the selector is on the right-hand side of and.
+	// Without results, the query never yields results.
+	roleFilter
+)
+
+// getSelectorRole determines the role of the given node from the set
+// operators along its path.
+// Guards stay guards, even if nested within other set operators, as parts of
+// a guard which never match are dead code in any case. Otherwise, the
+// innermost or makes a selector optional and the right-hand side of and makes
+// a required selector a filter.
+func getSelectorRole(node promql.Node, path []promql.Node) selectorRole {
+	role := roleRequired
+	for i, n := range path {
+		be, ok := n.(*promql.BinaryExpr)
+		if !ok || role == roleGuard {
+			continue
+		}
+		child := node
+		if i+1 < len(path) {
+			child = path[i+1]
+		}
+		rhs := child == promql.Node(be.RHS)
+		switch {
+		case be.Op == promql.LUNLESS && rhs:
+			role = roleGuard
+		case be.Op == promql.LOR:
+			role = roleOptional
+		case be.Op == promql.LAND && rhs && role == roleRequired:
+			role = roleFilter
+		}
+	}
+	return role
+}
+
+// orOperand identifies one operand of an or operator.
+type orOperand struct {
+	expr *promql.BinaryExpr
+	rhs  bool
+}
+
+// getOrOperands returns the operands of all or operators along the path of
+// the given node which contain it.
+func getOrOperands(node promql.Node, path []promql.Node) []orOperand {
+	var operands []orOperand
+	for i, n := range path {
+		be, ok := n.(*promql.BinaryExpr)
+		if !ok || be.Op != promql.LOR {
+			continue
+		}
+		child := node
+		if i+1 < len(path) {
+			child = path[i+1]
+		}
+		operands = append(operands, orOperand{expr: be, rhs: child == promql.Node(be.RHS)})
+	}
+	return operands
+}
+
+// orOperandResults tells which or operands of a query contain selectors and
+// whether any of them yields results.
+type orOperandResults struct {
+	seen       map[orOperand]bool
+	hasResults map[orOperand]bool
+}
+
+// getOrOperandResults determines the or operand results of a query from the
+// statuses of its pending selectors. Operands containing selectors within
+// absent() are assumed to yield results, as absent() does so if its
+// argument is empty.
+func getOrOperandResults(pending []pendingSelector, statuses []selectorStatus, absent []selectorRef) orOperandResults {
+	r := orOperandResults{seen: map[orOperand]bool{}, hasResults: map[orOperand]bool{}}
+	for i, p := range pending {
+		for _, o := range p.ors {
+			r.seen[o] = true
+			r.hasResults[o] = r.hasResults[o] || statuses[i] != selectorNoResults
+		}
+	}
+	for _, ref := range absent {
+		for _, o := range ref.ors {
+			r.seen[o] = true
+			r.hasResults[o] = true
+		}
+	}
+	return r
+}
+
+// hasFallback returns true if the other operand of any of the given or
+// operands may yield results, i.e. it yields results or contains no
+// selectors at all (e.g. vector(0)).
+func (r orOperandResults) hasFallback(operands []orOperand) bool {
+	for _, o := range operands {
+		other := orOperand{expr: o.expr, rhs: !o.rhs}
+		if !r.seen[other] || r.hasResults[other] {
+			return true
+		}
+	}
+	return false
+}
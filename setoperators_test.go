@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestGetSelectorRole(t *testing.T) {
+	c := map[string][]selectorRole{
+		"a + b":                          {roleRequired, roleRequired},
+		"a or b":                         {roleOptional, roleOptional},
+		"a unless b":                     {roleRequired, roleGuard},
+		"a and b":                        {roleRequired, roleFilter},
+		"(a and b) or c":                 {roleOptional, roleOptional, roleOptional},
+		"(a or b) and c":                 {roleOptional, roleOptional, roleFilter},
+		"a unless (b or c)":              {roleRequired, roleGuard, roleGuard},
+		"(a unless b) or c":              {roleOptional, roleGuard, roleOptional},
+		"sum(rate(a[5m])) and on(job) b": {roleRequired, roleFilter},
+	}
+	for q, e := range c {
+		refs, err := getSelectorRefs(q)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		var r []selectorRole
+		for _, ref := range refs {
+			r = append(r, ref.role)
+		}
+		if !reflect.DeepEqual(r, e) {
+			t.Errorf("%s: %v != %v", q, r, e)
+		}
+	}
+}
+
+func TestGetOrOperands(t *testing.T) {
+	c := map[string][][]bool{
+		"a + b":           {nil, nil},
+		"a or b":          {{false}, {true}},
+		"(a or b) or c":   {{false, false}, {false, true}, {true}},
+		"a or (b and c)":  {{false}, {true}, {true}},
+		"a unless b or c": {{false}, {false}, {true}},
+	}
+	for q, e := range c {
+		refs, err := getSelectorRefs(q)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		var r [][]bool
+		for _, ref := range refs {
+			var sides []bool
+			for _, o := range ref.ors {
+				sides = append(sides, o.rhs)
+			}
+			r = append(r, sides)
+		}
+		if !reflect.DeepEqual(r, e) {
+			t.Errorf("%s: %v != %v", q, r, e)
+		}
+	}
+}
+
+func TestGetNoResultSelectorsRoles(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			fmt.Fprint(w, `{"status":"success","data":["present"]}`)
+		case "/api/v1/query":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1,"1"]}]}}`)
+		default:
+			t.Errorf("unexpected request: %v", r.URL)
+		}
+	}))
+	defer ts.Close()
+
+	prefetch := *prefetchMetricNames
+	defer func() { *prefetchMetricNames = prefetch }()
+	*prefetchMetricNames = true
+	r, err := getNoResultSelectorsForQueries(newAPIClient(ts.URL, nil), []string{
+		"missing + present unless guard and filter",
+		"fallback or present",
+		"typo_a or typo_b",
+		"(typo_a or typo_b) or present",
+		"(typo_a or typo_b) or vector(0)",
+		"missing or absent(present)",
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	e := []selectorResults{
+		{noResults: []string{"missing"}, emptyGuards: []string{"guard"}, emptyFilters: []string{"filter"}},
+		{emptyFallbacks: []string{"fallback"}},
+		{noResults: []string{"typo_a", "typo_b"}},
+		{emptyFallbacks: []string{"typo_a", "typo_b"}},
+		{emptyFallbacks: []string{"typo_a", "typo_b"}},
+		{emptyFallbacks: []string{"missing"}},
+	}
+	if !reflect.DeepEqual(r, e) {
+		t.Errorf("%+v != %+v", r, e)
+	}
+}
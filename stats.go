@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runStatistics counts the work done (and avoided) during a run.
+// All fields are updated atomically, as servers are checked concurrently.
+type runStatistics struct {
+	// requests is the number of API requests sent.
+	requests uint64
+	// retries is the number of requests which were retried because the
+	// server was overloaded.
+	retries uint64
+	// skippedQueries is the number of selector queries which were not
+	// sent because the selector's metric does not exist.
+	skippedQueries uint64
+	// indexedSelectors is the number of selectors which were checked
+	// using the series index instead of a query.
+	indexedSelectors uint64
+	// cacheHits is the number of selectors whose result was already known.
+	cacheHits uint64
+}
+
+var stats runStatistics
+
+// add atomically adds delta to the given counter.
+func (s *runStatistics) add(counter *uint64, delta uint64) {
+	atomic.AddUint64(counter, delta)
+}
+
+// log logs the current statistics.
+func (s *runStatistics) log() {
+	log.WithFields(log.Fields{
+		"requests":         atomic.LoadUint64(&s.requests),
+		"retries":          atomic.LoadUint64(&s.retries),
+		"skippedQueries":   atomic.LoadUint64(&s.skippedQueries),
+		"indexedSelectors": atomic.LoadUint64(&s.indexedSelectors),
+		"cacheHits":        atomic.LoadUint64(&s.cacheHits),
+	}).Info("Run statistics")
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/prometheus/prometheus/model/labels"
+	promql "github.com/prometheus/prometheus/promql/parser"
+	log "github.com/sirupsen/logrus"
+)
+
+// suggestSelectors returns "did you mean" suggestions for each of the given
+// selectors which yield no results, keyed by selector.
+// If the selector's metric does not exist at all, replacement metrics are
+// suggested. Otherwise, replacements for its label matchers are suggested.
+func suggestSelectors(c apiClient, selectors []string) map[string][]string {
+	suggestions := map[string][]string{}
+	for _, selector := range selectors {
+		matchers, err := promql.ParseMetricSelector(selector)
+		if err != nil {
+			log.WithFields(log.Fields{"selector": selector, "err": err}).Warn("Metric selector parsing failed, not suggesting replacements")
+			continue
+		}
+		s, err := suggestMetrics(c, matchers)
+		if s == nil && err == nil {
+			s, err = suggestMatchers(c, matchers)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"selector": selector, "err": err}).Warn("Failed to retrieve suggestions")
+			continue
+		}
+		if len(s) > 0 {
+			suggestions[selector] = s
+		}
+	}
+	if len(suggestions) == 0 {
+		return nil
+	}
+	return suggestions
+}
+
+// suggestMetrics returns the given selector with its metric name replaced by
+// similar existing metrics if the metric does not exist.
+// It returns nil if the metric exists or the selector has no metric name.
+func suggestMetrics(c apiClient, matchers []*labels.Matcher) ([]string, error) {
+	metric := ""
+	for _, m := range matchers {
+		if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+			metric = m.Value
+		}
+	}
+	if metric == "" {
+		return nil, nil
+	}
+	mc, err := getMetricCatalogWithMetadata(c)
+	if err != nil {
+		return nil, err
+	}
+	if mc.exists[metric] {
+		return nil, nil
+	}
+	suggestions := []string{}
+	for _, name := range rankMetricNames(metric, mc, *suggestMax) {
+		var lms []*labels.Matcher
+		for _, m := range matchers {
+			if m.Name == labels.MetricName {
+				m = &labels.Matcher{Type: labels.MatchEqual, Name: labels.MetricName, Value: name}
+			}
+			lms = append(lms, m)
+		}
+		suggestions = append(suggestions, labelMatchersToString(lms))
+	}
+	return suggestions, nil
+}
+
+// metricUnits maps common unit suffixes of metric names to their quantity.
+var metricUnits = map[string]string{
+	"seconds":      "time",
+	"second":       "time",
+	"milliseconds": "time",
+	"ms":           "time",
+	"microseconds": "time",
+	"us":           "time",
+	"nanoseconds":  "time",
+	"ns":           "time",
+	"minutes":      "time",
+	"hours":        "time",
+	"bytes":        "size",
+	"kilobytes":    "size",
+	"kb":           "size",
+	"megabytes":    "size",
+	"mb":           "size",
+	"gigabytes":    "size",
+	"bits":         "size",
+	"ratio":        "ratio",
+	"percent":      "ratio",
+	"percentage":   "ratio",
+	"celsius":      "temperature",
+	"fahrenheit":   "temperature",
+	"joules":       "energy",
+	"watts":        "power",
+	"volts":        "voltage",
+	"amperes":      "current",
+	"meters":       "length",
+}
+
+// metricNameParts is a metric name split into its base tokens, unit, counter
+// suffix and histogram/summary suffix.
+type metricNameParts struct {
+	tokens []string
+	unit   string
+	total  bool
+	part   string
+}
+
+// splitMetricName splits the given metric name (e.g.
+// http_request_duration_seconds_bucket) into its parts. Tokens are
+// lowercased and plural forms are removed so that e.g. request and requests
+// are considered equal.
+func splitMetricName(name string) metricNameParts {
+	p := metricNameParts{
+		tokens: strings.FieldsFunc(strings.ToLower(name), func(r rune) bool { return r == '_' || r == ':' }),
+	}
+	last := func() string {
+		if len(p.tokens) < 2 {
+			return ""
+		}
+		return p.tokens[len(p.tokens)-1]
+	}
+	if l := last(); l == "count" || l == "sum" || l == "bucket" {
+		p.part = l
+		p.tokens = p.tokens[:len(p.tokens)-1]
+	}
+	if last() == "total" {
+		p.total = true
+		p.tokens = p.tokens[:len(p.tokens)-1]
+	}
+	if _, ok := metricUnits[last()]; ok {
+		p.unit = last()
+		p.tokens = p.tokens[:len(p.tokens)-1]
+	}
+	for i, t := range p.tokens {
+		if len(t) > 3 && strings.HasSuffix(t, "s") && !strings.HasSuffix(t, "ss") {
+			p.tokens[i] = strings.TrimSuffix(t, "s")
+		}
+	}
+	return p
+}
+
+// rankMetricNames returns up to max metrics of the given catalog which are
+// likely replacements for the given missing metric, most likely first.
+// Metrics are considered likely replacements if they only differ by an
+// added or removed _total suffix, a changed unit or an added or removed
+// prefix, if their names are similar or share most tokens. Candidates whose
+// HELP text mentions the tokens of the missing metric are preferred.
+func rankMetricNames(metric string, mc *metricCatalog, max int) []string {
+	type scored struct {
+		name  string
+		score float64
+	}
+	mp := splitMetricName(metric)
+	nm := normalizeCandidate(metric)
+	limit := len(nm) / 3
+	if limit < 2 {
+		limit = 2
+	}
+	var ranked []scored
+	for _, name := range mc.names {
+		if name == metric {
+			continue
+		}
+		cp := splitMetricName(name)
+		score := scoreMetricNameParts(mp, cp)
+		if score < 0.85 && editDistance(nm, normalizeCandidate(name)) <= limit {
+			score = 0.85
+		}
+		score += 0.4 * helpOverlap(mp.tokens, mc.help(name))
+		switch mc.metricType(name) {
+		case "counter":
+			if mp.total {
+				score += 0.05
+			}
+		case "histogram", "summary":
+			if mp.part != "" {
+				score += 0.05
+			}
+		}
+		if score < 0.5 {
+			continue
+		}
+		ranked = append(ranked, scored{name: name, score: score})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].name < ranked[j].name
+	})
+	var result []string
+	for i := 0; i < len(ranked) && i < max; i++ {
+		result = append(result, ranked[i].name)
+	}
+	return result
+}
+
+// scoreMetricNameParts returns how likely b is a renamed version of a, from 0
+// (unrelated) to 1 (only the _total suffix or plural forms differ).
+func scoreMetricNameParts(a, b metricNameParts) float64 {
+	sameTokens := reflect.DeepEqual(a.tokens, b.tokens)
+	sameUnit := a.unit == b.unit
+	compatibleUnit := sameUnit || a.unit == "" || b.unit == "" || metricUnits[a.unit] == metricUnits[b.unit]
+	// A prefix has been added or removed. Single tokens without unit or
+	// _total suffix are too generic for this (e.g. up and mysql_up).
+	prefixed := (hasTokenSuffix(a.tokens, b.tokens) || hasTokenSuffix(b.tokens, a.tokens)) &&
+		(a.unit != "" || a.total || (len(a.tokens) > 1 && len(b.tokens) > 1))
+	if a.part == b.part {
+		switch {
+		case sameTokens && sameUnit:
+			return 1
+		case sameTokens && compatibleUnit:
+			return 0.9
+		case compatibleUnit && prefixed:
+			return 0.8
+		}
+	}
+	setA, setB := map[string]bool{}, map[string]bool{}
+	for _, t := range a.tokens {
+		setA[t] = true
+	}
+	for _, t := range b.tokens {
+		setB[t] = true
+	}
+	if a.unit != "" {
+		setA["unit:"+metricUnits[a.unit]] = true
+	}
+	if b.unit != "" {
+		setB["unit:"+metricUnits[b.unit]] = true
+	}
+	common := 0
+	for t := range setA {
+		if setB[t] {
+			common++
+		}
+	}
+	union := len(setA) + len(setB) - common
+	if union == 0 {
+		return 0
+	}
+	score := 0.6 * float64(common) / float64(union)
+	if a.part != b.part {
+		score /= 2
+	}
+	return score
+}
+
+// hasTokenSuffix returns true if s ends with all tokens of suffix.
+func hasTokenSuffix(s, suffix []string) bool {
+	if len(suffix) == 0 || len(suffix) > len(s) {
+		return false
+	}
+	return reflect.DeepEqual(s[len(s)-len(suffix):], suffix)
+}
+
+// helpOverlap returns the fraction of the given (significant) tokens which
+// occur in the given HELP text.
+func helpOverlap(tokens []string, help string) float64 {
+	if help == "" {
+		return 0
+	}
+	words := map[string]bool{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(help), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		words[w] = true
+		if len(w) > 3 && strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss") {
+			words[strings.TrimSuffix(w, "s")] = true
+		}
+	}
+	total, found := 0, 0
+	for _, t := range tokens {
+		if len(t) < 3 {
+			continue
+		}
+		total++
+		if words[t] {
+			found++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(found) / float64(total)
+}
+
+// containsString returns true if the given strings contain s.
+func containsString(strs []string, s string) bool {
+	for _, v := range strs {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestMatchers returns replacements for the equality matchers of the given
+// selector whose label name or value does not exist for the selector's
+// metric. Suggestions are based on the label names and values which actually
+// exist for the metric.
+// Selectors without metric name are not handled.
+func suggestMatchers(c apiClient, matchers []*labels.Matcher) ([]string, error) {
+	metric := ""
+	for _, m := range matchers {
+		if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+			metric = m.Value
+		}
+	}
+	if metric == "" {
+		return nil, nil
+	}
+	var suggestions []string
+	var names []string
+	for _, m := range matchers {
+		if m.Name == labels.MetricName || m.Type != labels.MatchEqual || m.Value == "" {
+			continue
+		}
+		values, err := getLabelValues(c, m.Name, []string{metric})
+		if err != nil {
+			return nil, err
+		}
+		if containsString(values, m.Value) {
+			// The value exists, so that another matcher causes
+			// the selector to be empty.
+			continue
+		}
+		if len(values) > 0 {
+			for _, v := range rankCandidates(m.Value, values, *suggestMax) {
+				suggestions = append(suggestions, (&labels.Matcher{Type: m.Type, Name: m.Name, Value: v}).String())
+			}
+			continue
+		}
+		// The label does not exist for this metric at all.
+		if names == nil {
+			names, err = getLabelNames(c, []string{metric})
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, n := range rankLabelNames(m.Name, names, *suggestMax) {
+			suggestions = append(suggestions, (&labels.Matcher{Type: m.Type, Name: n, Value: m.Value}).String())
+		}
+	}
+	return suggestions, nil
+}
+
+// labelNameAliases lists label names which are commonly used for the same
+// purpose, so that e.g. host can be suggested to be replaced by instance.
+var labelNameAliases = map[string][]string{
+	"host":        {"instance", "hostname", "node"},
+	"hostname":    {"instance", "host", "node"},
+	"node":        {"instance", "host", "hostname"},
+	"server":      {"instance", "host"},
+	"instance":    {"host", "hostname", "node"},
+	"service":     {"job", "app"},
+	"app":         {"job", "service", "application"},
+	"application": {"app", "job", "service"},
+	"env":         {"environment"},
+	"environment": {"env"},
+}
+
+// rankLabelNames works like rankCandidates, but ranks existing aliases of
+// the given label name first.
+func rankLabelNames(name string, names []string, max int) []string {
+	exists := map[string]bool{}
+	for _, n := range names {
+		exists[n] = true
+	}
+	var result []string
+	for _, alias := range labelNameAliases[name] {
+		if exists[alias] && len(result) < max {
+			result = append(result, alias)
+		}
+	}
+	for _, n := range rankCandidates(name, names, max) {
+		if len(result) >= max {
+			break
+		}
+		isAlias := false
+		for _, r := range result {
+			isAlias = isAlias || r == n
+		}
+		if !isAlias {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// rankCandidates returns up to max of the given candidates which are similar
+// to s, most similar first. s itself is never returned.
+// Similarity is based on the edit distance after normalizing case and
+// separators (so that node-exporter and Node_Exporter are considered equal).
+// Candidates which contain s or are contained in it are considered similar
+// as well.
+func rankCandidates(s string, candidates []string, max int) []string {
+	type scored struct {
+		candidate  string
+		normalized int
+		raw        int
+	}
+	ns := normalizeCandidate(s)
+	limit := len(ns) / 3
+	if limit < 2 {
+		limit = 2
+	}
+	var ranked []scored
+	for _, c := range candidates {
+		if c == s {
+			continue
+		}
+		nc := normalizeCandidate(c)
+		d := editDistance(ns, nc)
+		if d > 1 && len(ns) > 2 && len(nc) > 2 && (strings.Contains(nc, ns) || strings.Contains(ns, nc)) {
+			d = 1
+		}
+		if d > limit {
+			continue
+		}
+		ranked = append(ranked, scored{candidate: c, normalized: d, raw: editDistance(s, c)})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].normalized != ranked[j].normalized {
+			return ranked[i].normalized < ranked[j].normalized
+		}
+		if ranked[i].raw != ranked[j].raw {
+			return ranked[i].raw < ranked[j].raw
+		}
+		return ranked[i].candidate < ranked[j].candidate
+	})
+	var result []string
+	for i := 0; i < len(ranked) && i < max; i++ {
+		result = append(result, ranked[i].candidate)
+	}
+	return result
+}
+
+// normalizeCandidate lowercases s and unifies common separators.
+func normalizeCandidate(s string) string {
+	return strings.NewReplacer("-", "_", ".", "_", " ", "_").Replace(strings.ToLower(s))
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// formatSuggestions formats the given suggestions for human-readable output.
+func formatSuggestions(suggestions []string) string {
+	return fmt.Sprintf("did you mean: %s?", strings.Join(suggestions, ", "))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	promql "github.com/prometheus/prometheus/promql/parser"
+)
+
+func TestRankCandidates(t *testing.T) {
+	c := []struct {
+		s          string
+		candidates []string
+		e          []string
+	}{
+		{
+			s:          "node-exporter",
+			candidates: []string{"prometheus", "node_exporter", "Node-Exporter", "node-exporter-2", "blackbox"},
+			e:          []string{"node_exporter", "Node-Exporter", "node-exporter-2"},
+		},
+		{
+			s:          "hots",
+			candidates: []string{"instance", "job", "hostname", "host"},
+			e:          []string{"host"},
+		},
+		{
+			s:          "prod",
+			candidates: []string{"production", "staging", "dev"},
+			e:          []string{"production"},
+		},
+		{
+			s:          "xyz",
+			candidates: []string{"production", "staging"},
+			e:          nil,
+		},
+	}
+	for _, x := range c {
+		r := rankCandidates(x.s, x.candidates, 3)
+		if !reflect.DeepEqual(r, x.e) {
+			t.Errorf("%s: %v != %v", x.s, r, x.e)
+		}
+	}
+}
+
+func TestRankLabelNames(t *testing.T) {
+	r := rankLabelNames("host", []string{"__name__", "instance", "job", "hostname", "hosts"}, 3)
+	e := []string{"instance", "hostname", "hosts"}
+	if !reflect.DeepEqual(r, e) {
+		t.Errorf("%v != %v", r, e)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	c := map[[2]string]int{
+		{"", ""}:                0,
+		{"abc", ""}:             3,
+		{"kitten", "sitting"}:   3,
+		{"node", "node"}:        0,
+		{"instance", "instnce"}: 1,
+	}
+	for x, e := range c {
+		d := editDistance(x[0], x[1])
+		if d != e {
+			t.Errorf("%v: %d != %d", x, d, e)
+		}
+	}
+}
+
+func TestRankMetricNames(t *testing.T) {
+	mc := newMetricCatalog([]string{
+		"node_cpu_seconds_total",
+		"node_network_receive_bytes_total",
+		"http_requests_total",
+		"http_request_duration_seconds_bucket",
+		"http_request_duration_milliseconds_bucket",
+		"process_open_fds",
+		"up",
+	}, map[string][]metricMetadata{
+		"process_open_fds": {{Type: "gauge", Help: "Number of open file descriptors."}},
+	})
+	c := map[string][]string{
+		"node_cpu":                                  {"node_cpu_seconds_total"},
+		"node_network_receive_bytes":                {"node_network_receive_bytes_total"},
+		"http_request_total":                        {"http_requests_total"},
+		"http_request_duration_microseconds_bucket": {"http_request_duration_milliseconds_bucket", "http_request_duration_seconds_bucket"},
+		"cpu_seconds_total":                         {"node_cpu_seconds_total"},
+		"open_file_descriptors":                     {"process_open_fds"},
+		"mysql_up":                                  nil,
+	}
+	for metric, e := range c {
+		r := rankMetricNames(metric, mc, 3)
+		if !reflect.DeepEqual(r, e) {
+			t.Errorf("%s: %v != %v", metric, r, e)
+		}
+	}
+}
+
+func TestSuggestMatchers(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/label/job/values":
+			fmt.Fprint(w, `{"status":"success","data":["node","node2"]}`)
+		case "/api/v1/label/instance/values":
+			fmt.Fprint(w, `{"status":"success","data":["host-a:9100","host-b:9100"]}`)
+		default:
+			t.Errorf("unexpected request: %v", r.URL)
+		}
+	}))
+	defer ts.Close()
+
+	max := *suggestMax
+	defer func() { *suggestMax = max }()
+	*suggestMax = 3
+	matchers, err := promql.ParseMetricSelector(`up{job="node",instance="host-a:9101"}`)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	r, err := suggestMatchers(newAPIClient(ts.URL, nil), matchers)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, s := range r {
+		if strings.HasPrefix(s, "job=") {
+			t.Errorf("suggestion for existing value: %v", r)
+		}
+	}
+	if len(r) == 0 || r[0] != `instance="host-a:9100"` {
+		t.Errorf("unexpected suggestions: %v", r)
+	}
+}
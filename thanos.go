@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// getRuleGroupsFromThanosRulers retrieves all rule groups from the given
+// Thanos Ruler base URLs, which provide the Prometheus rules API.
+func getRuleGroupsFromThanosRulers(urls []string) ([]ruleGroup, error) {
+	var groups []ruleGroup
+	for _, u := range urls {
+		log.WithFields(log.Fields{"thanos.ruler-url": u}).Debug("Retrieving rules")
+		g, err := getRuleGroupsFromAPI(newAPIClient(u, nil))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", u, err)
+		}
+		groups = append(groups, g...)
+	}
+	return groups, nil
+}
+
+// thanosQueryParams returns the Thanos-specific query parameters which have
+// been configured on the command line.
+func thanosQueryParams() url.Values {
+	params := url.Values{}
+	if *thanosDedup != "" {
+		params.Set("dedup", *thanosDedup)
+	}
+	if *thanosPartialResponse != "" {
+		params.Set("partial_response", *thanosPartialResponse)
+	}
+	if *thanosMaxSourceRes != "" {
+		params.Set("max_source_resolution", *thanosMaxSourceRes)
+	}
+	return params
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetNoResultSelectorsPartialResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("partial_response") != "true" {
+			t.Errorf("partial_response parameter missing: %v", r.URL)
+		}
+		q := r.URL.Query().Get("query")
+		switch {
+		case strings.Contains(q, "present"):
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1,"3"]}]}}`)
+		case strings.Contains(q, "partial"):
+			fmt.Fprint(w, `{"status":"success","warnings":["store unavailable"],"data":{"resultType":"vector","result":[]}}`)
+		case strings.Contains(q, "annotated"):
+			fmt.Fprint(w, `{"status":"success","warnings":["PromQL info: metric might not be a counter, name does not end in _total/_sum/_count/_bucket: \"annotated\""],"data":{"resultType":"vector","result":[]}}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+		}
+	}))
+	defer ts.Close()
+
+	c := newAPIClient(ts.URL, nil)
+	c.params = map[string][]string{"partial_response": {"true"}}
+	r, err := getNoResultSelectors(c, "present + partial + missing + rate(annotated[5m])")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !reflect.DeepEqual(r.noResults, []string{"missing", "annotated"}) {
+		t.Errorf("unexpected no result selectors: %v", r.noResults)
+	}
+	if !reflect.DeepEqual(r.inconclusive, []string{"partial"}) {
+		t.Errorf("unexpected inconclusive selectors: %v", r.inconclusive)
+	}
+}
+
+func TestIsPartialResponse(t *testing.T) {
+	c := newAPIClient("http://127.0.0.1:10902", nil)
+	if !isPartialResponse(c, []string{"store unavailable"}) {
+		t.Errorf("no partial response despite store warning with default parameters")
+	}
+	if isPartialResponse(c, []string{"PromQL info: metric might not be a counter"}) {
+		t.Errorf("partial response due to PromQL annotation")
+	}
+	c.params = map[string][]string{"partial_response": {"true"}}
+	if !isPartialResponse(c, []string{"store unavailable"}) {
+		t.Errorf("no partial response despite store warning")
+	}
+	c.params = map[string][]string{"partial_response": {"false"}}
+	if isPartialResponse(c, []string{"store unavailable"}) {
+		t.Errorf("partial response despite partial_response=false")
+	}
+}
+
+func TestGetNoResultSelectorsDefaultPartialResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["partial_response"]; ok {
+			t.Errorf("unexpected partial_response parameter: %v", r.URL)
+		}
+		q := r.URL.Query().Get("query")
+		switch {
+		case strings.Contains(q, "partial"):
+			fmt.Fprint(w, `{"status":"success","warnings":["fetch series for {replica=\"a\"}: store unavailable"],"data":{"resultType":"vector","result":[]}}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+		}
+	}))
+	defer ts.Close()
+
+	r, err := getNoResultSelectors(newAPIClient(ts.URL, nil), "partial + missing")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !reflect.DeepEqual(r.noResults, []string{"missing"}) {
+		t.Errorf("unexpected no result selectors: %v", r.noResults)
+	}
+	if !reflect.DeepEqual(r.inconclusive, []string{"partial"}) {
+		t.Errorf("unexpected inconclusive selectors: %v", r.inconclusive)
+	}
+}